@@ -0,0 +1,171 @@
+package why
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Access log formats supported by NewAccessLog.
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+)
+
+// AccessEntry contains the information about a handled request.
+type AccessEntry struct {
+	Time       time.Time     `json:"time"`
+	RequestID  string        `json:"request_id"`
+	RemoteAddr string        `json:"remote_addr"`
+	Method     string        `json:"method"`
+	URI        string        `json:"uri"`
+	Path       string        `json:"path"`
+	Proto      string        `json:"proto"`
+	Script     string        `json:"script,omitempty"`
+	Status     int           `json:"status"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"duration_ns"`
+	Cache      string        `json:"cache,omitempty"`
+	Referer    string        `json:"referer,omitempty"`
+	UserAgent  string        `json:"user_agent,omitempty"`
+}
+
+// AccessLogger receives a entry for each request handled by the
+// server. It can be implemented to send access logs to other
+// logging systems.
+type AccessLogger interface {
+	LogAccess(entry *AccessEntry)
+}
+
+type accessLog struct {
+	mtx    sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// NewAccessLog creates a AccessLogger that writes the entries in
+// the given format (common, combined or json) to the writer.
+func NewAccessLog(w io.Writer, format string) (AccessLogger, error) {
+	switch format {
+	case "":
+		format = AccessLogCommon
+	case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+	default:
+		return nil, errors.Errorf("unknown access log format '%s'", format)
+	}
+
+	return &accessLog{
+		w:      w,
+		format: format,
+	}, nil
+}
+
+func clfValue(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
+
+// clfHost returns the host of the remote address without the port,
+// as the host field of the common log format only contains the ip.
+func clfHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return clfValue(host)
+	}
+	return clfValue(addr)
+}
+
+// LogAccess writes the entry to the writer.
+func (al *accessLog) LogAccess(entry *AccessEntry) {
+	var line []byte
+
+	switch al.format {
+	case AccessLogJSON:
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		line = append(data, '\n')
+	default:
+		bytes := "-"
+		if entry.Bytes > 0 {
+			bytes = strconv.FormatInt(entry.Bytes, 10)
+		}
+
+		line = []byte(fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+			clfHost(entry.RemoteAddr),
+			entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			entry.Method, entry.URI, entry.Proto,
+			entry.Status, bytes,
+		))
+
+		if al.format == AccessLogCombined {
+			line = append(line, fmt.Sprintf(" %q %q", clfValue(entry.Referer), clfValue(entry.UserAgent))...)
+		}
+
+		line = append(line, '\n')
+	}
+
+	al.mtx.Lock()
+	_, _ = al.w.Write(line)
+	al.mtx.Unlock()
+}
+
+// LogFile is a append-only log file that can be reopened. This
+// makes it possible to rotate the file with tools like logrotate
+// by reopening it on SIGHUP.
+type LogFile struct {
+	mtx  sync.Mutex
+	path string
+	file *os.File
+}
+
+// OpenLogFile opens or creates the log file at the given path.
+func OpenLogFile(path string) (*LogFile, error) {
+	lf := &LogFile{path: path}
+	if err := lf.Reopen(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+// Reopen closes the current file and opens the path again.
+func (lf *LogFile) Reopen() error {
+	file, err := os.OpenFile(lf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	lf.mtx.Lock()
+	old := lf.file
+	lf.file = file
+	lf.mtx.Unlock()
+
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Write appends the data to the file.
+func (lf *LogFile) Write(data []byte) (int, error) {
+	lf.mtx.Lock()
+	defer lf.mtx.Unlock()
+	return lf.file.Write(data)
+}
+
+// Close closes the file.
+func (lf *LogFile) Close() error {
+	lf.mtx.Lock()
+	defer lf.mtx.Unlock()
+	return lf.file.Close()
+}
@@ -0,0 +1,73 @@
+package why
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	entry := &AccessEntry{
+		Time:       time.Date(2019, time.June, 13, 10, 20, 30, 0, time.UTC),
+		RequestID:  "abc",
+		RemoteAddr: "1.2.3.4:5678",
+		Method:     "GET",
+		URI:        "/index?a=1",
+		Path:       "/index",
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		Bytes:      42,
+		Duration:   time.Millisecond,
+		UserAgent:  "curl",
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: "", want: "1.2.3.4 - - [13/Jun/2019:10:20:30 +0000] \"GET /index?a=1 HTTP/1.1\" 200 42\n"},
+		{format: AccessLogCommon, want: "1.2.3.4 - - [13/Jun/2019:10:20:30 +0000] \"GET /index?a=1 HTTP/1.1\" 200 42\n"},
+		{format: AccessLogCombined, want: "1.2.3.4 - - [13/Jun/2019:10:20:30 +0000] \"GET /index?a=1 HTTP/1.1\" 200 42 \"-\" \"curl\"\n"},
+		{format: AccessLogJSON, want: `{"time":"2019-06-13T10:20:30Z","request_id":"abc","remote_addr":"1.2.3.4:5678","method":"GET","uri":"/index?a=1","path":"/index","proto":"HTTP/1.1","status":200,"bytes":42,"duration_ns":1000000,"user_agent":"curl"}` + "\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := NewAccessLog(&buf, test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			logger.LogAccess(entry)
+			if buf.String() != test.want {
+				t.Fatalf("expected:\n%s\ngot:\n%s", test.want, buf.String())
+			}
+		})
+	}
+
+	if _, err := NewAccessLog(&bytes.Buffer{}, "xml"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
+
+func TestCLFHost(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: "1.2.3.4:5678", want: "1.2.3.4"},
+		{addr: "[::1]:5678", want: "::1"},
+		{addr: "1.2.3.4", want: "1.2.3.4"},
+		{addr: "@", want: "@"},
+		{addr: "", want: "-"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.addr, func(t *testing.T) {
+			if got := clfHost(test.addr); got != test.want {
+				t.Fatalf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package why
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// HealthExtension can be implemented by extensions that can check if
+// they are still working (e.g. a database connection is alive). The
+// result is reflected by the readiness endpoint.
+type HealthExtension interface {
+	HealthCheck() error
+}
+
+type extensionStatus struct {
+	Name    string   `json:"name"`
+	Vars    []string `json:"vars"`
+	Healthy bool     `json:"healthy"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// extensionStatus runs the health checks of all extensions.
+func (s *Server) extensionStatus() ([]extensionStatus, bool) {
+	healthy := true
+	status := make([]extensionStatus, len(s.extensions))
+	for i := range s.extensions {
+		status[i] = extensionStatus{
+			Name:    s.extensionName(i),
+			Vars:    s.extensionVars(i),
+			Healthy: true,
+		}
+
+		if checker, ok := s.extensions[i].(HealthExtension); ok {
+			if err := checker.HealthCheck(); err != nil {
+				status[i].Healthy = false
+				status[i].Error = err.Error()
+				healthy = false
+			}
+		}
+	}
+	return status, healthy
+}
+
+// HealthHandler returns a handler that reports if the server
+// process is alive.
+func (s *Server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// ReadyHandler returns a handler that reports if the server is ready
+// to handle requests. The server isn't ready if the extensions aren't
+// initialized, a health check of a extension fails or the server is
+// shutting down.
+func (s *Server) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		extensions, healthy := s.extensionStatus()
+
+		status, code := "ready", http.StatusOK
+		switch {
+		case s.executions.isDraining():
+			status, code = "shutting down", http.StatusServiceUnavailable
+		case !s.initialized.Load():
+			status, code = "not initialized", http.StatusServiceUnavailable
+		case !healthy:
+			status, code = "unhealthy", http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, code, map[string]interface{}{
+			"status":     status,
+			"extensions": extensions,
+		})
+	})
+}
+
+// AdminHandler returns a handler for the admin api. All requests need
+// the configured AdminToken as bearer token. The handler expects the
+// admin path to be stripped. Available endpoints:
+//
+//	GET  /scripts       lists the compiled scripts in the cache
+//	POST /cache/purge   removes all compiled scripts from the cache
+//	GET  /extensions    lists the loaded extensions and their health
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/scripts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		writeJSON(w, http.StatusOK, s.cache.list())
+	})
+
+	mux.HandleFunc("/cache/purge", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"purged": s.cache.purge()})
+	})
+
+	mux.HandleFunc("/extensions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		extensions, _ := s.extensionStatus()
+		writeJSON(w, http.StatusOK, extensions)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if len(s.conf.AdminToken) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(s.conf.AdminToken)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// InternalHandler returns a handler that serves the metrics, health,
+// readiness and admin endpoints. All other requests are answered
+// with 404. This is the handler of the InternalAddress listener.
+func (s *Server) InternalHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.serveInternal(w, r) {
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// internalEnabled returns true if any internal endpoint is configured.
+func (s *Server) internalEnabled() bool {
+	return len(s.conf.MetricsPath) > 0 || len(s.conf.HealthPath) > 0 || len(s.conf.ReadyPath) > 0 || (len(s.conf.AdminPath) > 0 && len(s.conf.AdminToken) > 0)
+}
+
+// serveInternal handles the metrics, health, readiness and admin
+// endpoints. If the request was handled true is returned.
+func (s *Server) serveInternal(w http.ResponseWriter, r *http.Request) bool {
+	path := r.URL.Path
+
+	switch {
+	case len(s.conf.MetricsPath) > 0 && path == s.conf.MetricsPath:
+		s.MetricsHandler().ServeHTTP(w, r)
+	case len(s.conf.HealthPath) > 0 && path == s.conf.HealthPath:
+		s.HealthHandler().ServeHTTP(w, r)
+	case len(s.conf.ReadyPath) > 0 && path == s.conf.ReadyPath:
+		s.ReadyHandler().ServeHTTP(w, r)
+	case len(s.conf.AdminPath) > 0 && len(s.conf.AdminToken) > 0 && strings.HasPrefix(path, strings.TrimRight(s.conf.AdminPath, "/")+"/"):
+		http.StripPrefix(strings.TrimRight(s.conf.AdminPath, "/"), s.AdminHandler()).ServeHTTP(w, r)
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,77 @@
+package why
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestInternalRouting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "why")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	denyAll := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+
+	tests := []struct {
+		name       string
+		conf       Config
+		middleware Middleware
+		path       string
+		want       int
+	}{
+		{name: "not exposed", conf: Config{HealthPath: "/healthz"}, path: "/healthz", want: http.StatusNotFound},
+		{name: "exposed", conf: Config{HealthPath: "/healthz", ExposeInternal: true}, path: "/healthz", want: http.StatusOK},
+		{name: "exposed under base path", conf: Config{BasePath: "/app", HealthPath: "/healthz", ExposeInternal: true}, path: "/app/healthz", want: http.StatusOK},
+		{name: "exposed outside base path", conf: Config{BasePath: "/app", HealthPath: "/healthz", ExposeInternal: true}, path: "/healthz", want: http.StatusNotFound},
+		{name: "exposed behind middleware", conf: Config{HealthPath: "/healthz", ExposeInternal: true}, middleware: denyAll, path: "/healthz", want: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := test.conf
+			conf.PublicDir = dir
+
+			s := New(&conf)
+			if test.middleware != nil {
+				if err := s.Use(test.middleware); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if rec.Code != test.want {
+				t.Fatalf("expected status %d, got %d", test.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestInternalHandler(t *testing.T) {
+	s := New(&Config{HealthPath: "/healthz"})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/healthz", want: http.StatusOK},
+		{path: "/index", want: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		s.InternalHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.want {
+			t.Fatalf("%s: expected status %d, got %d", test.path, test.want, rec.Code)
+		}
+	}
+}
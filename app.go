@@ -0,0 +1,86 @@
+package why
+
+import (
+	"math"
+	"os"
+	"strings"
+
+	"github.com/d5/tengo/objects"
+)
+
+// appModule is the name of the module that contains the app
+// config and the allowed environment variables.
+const appModule = "app"
+
+// newAppModule builds the attributes of the read-only app module with
+// the CONFIG and ENV maps. It is built one time and shared by all
+// scripts.
+func newAppModule(conf *Config) (map[string]objects.Object, error) {
+	config, err := ToObject(conf.AppConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]objects.Object{
+		"CONFIG": immutable(config),
+		"ENV":    allowedEnv(conf.AppEnv),
+	}, nil
+}
+
+// allowedEnv returns the environment variables that match the allowlist.
+// Entries ending with "*" allow all variables with the prefix.
+func allowedEnv(allowed []string) *objects.ImmutableMap {
+	m := &objects.ImmutableMap{Value: map[string]objects.Object{}}
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		for _, pattern := range allowed {
+			if parts[0] == pattern || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(parts[0], strings.TrimSuffix(pattern, "*"))) {
+				m.Value[parts[0]] = &objects.String{Value: parts[1]}
+				break
+			}
+		}
+	}
+	return m
+}
+
+// immutable converts maps and arrays recursively to their immutable
+// variants, so scripts can't modify the shared values. Whole floats
+// are converted to ints, because numbers from JSON configs are
+// always floats.
+func immutable(o objects.Object) objects.Object {
+	switch o := o.(type) {
+	case *objects.Map:
+		m := &objects.ImmutableMap{Value: make(map[string]objects.Object, len(o.Value))}
+		for key, value := range o.Value {
+			m.Value[key] = immutable(value)
+		}
+		return m
+	case *objects.ImmutableMap:
+		m := &objects.ImmutableMap{Value: make(map[string]objects.Object, len(o.Value))}
+		for key, value := range o.Value {
+			m.Value[key] = immutable(value)
+		}
+		return m
+	case *objects.Array:
+		arr := &objects.ImmutableArray{Value: make([]objects.Object, len(o.Value))}
+		for i := range o.Value {
+			arr.Value[i] = immutable(o.Value[i])
+		}
+		return arr
+	case *objects.ImmutableArray:
+		arr := &objects.ImmutableArray{Value: make([]objects.Object, len(o.Value))}
+		for i := range o.Value {
+			arr.Value[i] = immutable(o.Value[i])
+		}
+		return arr
+	case *objects.Float:
+		if o.Value == math.Trunc(o.Value) && math.Abs(o.Value) < 1<<53 {
+			return &objects.Int{Value: int64(o.Value)}
+		}
+	}
+	return o
+}
@@ -0,0 +1,53 @@
+package why
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/d5/tengo/objects"
+)
+
+func TestAppModule(t *testing.T) {
+	os.Setenv("WHY_TEST_FEATURE_A", "on")
+	os.Setenv("WHY_TEST_SECRET", "hidden")
+	defer os.Unsetenv("WHY_TEST_FEATURE_A")
+	defer os.Unsetenv("WHY_TEST_SECRET")
+
+	s := New(&Config{
+		AppConfig: map[string]interface{}{
+			"title": "Site",
+			"limit": 10.0,
+			"tags":  []interface{}{"a", 1.5},
+		},
+		AppEnv: []string{"WHY_TEST_FEATURE_*"},
+	})
+
+	mod, ok := s.stdModules.Get(appModule).(*objects.BuiltinModule)
+	if !ok {
+		t.Fatal("expected the app module to be registered")
+	}
+
+	config := objects.ToInterface(mod.Attrs["CONFIG"])
+	want := map[string]interface{}{
+		"title": "Site",
+		"limit": int64(10),
+		"tags":  []interface{}{"a", 1.5},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Fatalf("expected config %#v, got %#v", want, config)
+	}
+
+	if _, ok := mod.Attrs["CONFIG"].(*objects.ImmutableMap).Value["tags"].(*objects.ImmutableArray); !ok {
+		t.Fatal("expected nested arrays to be immutable")
+	}
+
+	env := objects.ToInterface(mod.Attrs["ENV"])
+	if !reflect.DeepEqual(env, map[string]interface{}{"WHY_TEST_FEATURE_A": "on"}) {
+		t.Fatalf("unexpected env %#v", env)
+	}
+
+	if s.reservedVariables()[appModule] {
+		t.Fatal("expected app not to be a reserved variable")
+	}
+}
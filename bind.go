@@ -0,0 +1,324 @@
+package why
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/d5/tengo/objects"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+var (
+	objectType  = reflect.TypeOf((*objects.Object)(nil)).Elem()
+	interopType = reflect.TypeOf((*objects.Interop)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*RequestContext)(nil))
+	timeType    = reflect.TypeOf(time.Time{})
+	bytesType   = reflect.TypeOf([]byte(nil))
+)
+
+// Bind turns a go function into a ContextFunc. The arguments of the
+// script are checked and converted to the parameter types of the
+// function and the return value is converted to a tengo object, so
+// extension functions can be written as plain go functions:
+//
+//	why.Bind(func(bucket, key string, value interface{}) error { ... })
+//
+// The function can optionally take a *RequestContext and after that a
+// objects.Interop as first parameters. The context is nil if the
+// function is used as module function. Parameters of type objects.Object
+// are passed unconverted (e.g. for callbacks), structs, maps and slices
+// are decoded with their json tag names. The function can return
+// nothing, a value, a error or a value and a error. A returned error
+// is passed to the script as error object.
+func Bind(fn interface{}) (ContextFunc, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return nil, errors.Errorf("%T is not a function", fn)
+	}
+
+	t := v.Type()
+
+	// Check for the optional context and interop parameters.
+	offset := 0
+	withContext := t.NumIn() > offset && t.In(offset) == contextType
+	if withContext {
+		offset++
+	}
+	withInterop := t.NumIn() > offset && t.In(offset) == interopType
+	if withInterop {
+		offset++
+	}
+
+	params := make([]reflect.Type, 0, t.NumIn()-offset)
+	for i := offset; i < t.NumIn(); i++ {
+		params = append(params, t.In(i))
+	}
+
+	switch {
+	case t.NumOut() > 2:
+		return nil, errors.New("function returns more than two values")
+	case t.NumOut() == 2 && t.Out(1) != errorType:
+		return nil, errors.New("second return value is not a error")
+	}
+
+	return func(ctx *RequestContext, interop objects.Interop, args ...objects.Object) (objects.Object, error) {
+		in, err := bindArgs(params, t.IsVariadic(), args)
+		if err != nil {
+			return nil, err
+		}
+
+		if withInterop {
+			in = append([]reflect.Value{reflect.ValueOf(&interop).Elem()}, in...)
+		}
+		if withContext {
+			in = append([]reflect.Value{reflect.ValueOf(ctx)}, in...)
+		}
+
+		var out []reflect.Value
+		if t.IsVariadic() {
+			out = v.CallSlice(in)
+		} else {
+			out = v.Call(in)
+		}
+
+		// Errors are passed to the script as error object.
+		if len(out) > 0 && t.Out(len(out)-1) == errorType {
+			if err, ok := out[len(out)-1].Interface().(error); ok && err != nil {
+				return ToError(err), nil
+			}
+			out = out[:len(out)-1]
+		}
+
+		if len(out) == 0 {
+			return nil, nil
+		}
+
+		return ToObject(out[0].Interface())
+	}, nil
+}
+
+// MustBind is like Bind but panics if the function can't be bound.
+// This is useful to bind functions in the map returned by Register.
+func MustBind(fn interface{}) ContextFunc {
+	f, err := Bind(fn)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// bindArgs checks the number of arguments and converts them to
+// the parameter types.
+func bindArgs(params []reflect.Type, variadic bool, args []objects.Object) ([]reflect.Value, error) {
+	fixed := len(params)
+	if variadic {
+		fixed--
+		if len(args) < fixed {
+			return nil, objects.ErrWrongNumArguments
+		}
+	} else if len(args) != fixed {
+		return nil, objects.ErrWrongNumArguments
+	}
+
+	in := make([]reflect.Value, 0, len(params))
+	for i := 0; i < fixed; i++ {
+		arg, err := fromObject(args[i], params[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "argument %d", i+1)
+		}
+		in = append(in, arg)
+	}
+
+	if variadic {
+		rest := reflect.MakeSlice(params[fixed], 0, len(args)-fixed)
+		for i := fixed; i < len(args); i++ {
+			arg, err := fromObject(args[i], params[fixed].Elem())
+			if err != nil {
+				return nil, errors.Wrapf(err, "argument %d", i+1)
+			}
+			rest = reflect.Append(rest, arg)
+		}
+		in = append(in, rest)
+	}
+
+	return in, nil
+}
+
+// fromObject converts a tengo object to a value of the given type.
+func fromObject(o objects.Object, t reflect.Type) (reflect.Value, error) {
+	if t == objectType {
+		if o == nil {
+			o = objects.UndefinedValue
+		}
+		return reflect.ValueOf(&o).Elem(), nil
+	}
+
+	value := reflect.New(t).Elem()
+
+	switch {
+	case t == timeType:
+		tm, ok := objects.ToTime(o)
+		if !ok {
+			return value, errors.New("not a time")
+		}
+		value.Set(reflect.ValueOf(tm))
+		return value, nil
+	case t == bytesType:
+		b, ok := objects.ToByteSlice(o)
+		if !ok {
+			return value, errors.New("not bytes")
+		}
+		value.SetBytes(b)
+		return value, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		s, ok := objects.ToString(o)
+		if !ok {
+			return value, errors.New("not a string")
+		}
+		value.SetString(s)
+	case reflect.Bool:
+		// objects.ToBool converts every object, so only real
+		// bools are accepted.
+		b, ok := o.(*objects.Bool)
+		if !ok {
+			return value, errors.New("not a bool")
+		}
+		value.SetBool(!b.IsFalsy())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := objects.ToInt64(o)
+		if !ok {
+			return value, errors.New("not a int")
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, ok := objects.ToInt64(o)
+		if !ok || i < 0 {
+			return value, errors.New("not a positive int")
+		}
+		value.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, ok := objects.ToFloat64(o)
+		if !ok {
+			return value, errors.New("not a float")
+		}
+		value.SetFloat(f)
+	case reflect.Interface:
+		if t.NumMethod() > 0 {
+			return value, errors.Errorf("unsupported parameter type %s", t)
+		}
+		if v := objects.ToInterface(o); v != nil {
+			value.Set(reflect.ValueOf(v))
+		}
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Ptr:
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			TagName: "json",
+			Result:  value.Addr().Interface(),
+		})
+		if err != nil {
+			return value, err
+		}
+		if err := decoder.Decode(objects.ToInterface(o)); err != nil {
+			return value, err
+		}
+	default:
+		return value, errors.Errorf("unsupported parameter type %s", t)
+	}
+
+	return value, nil
+}
+
+// ToObject converts a go value to a tengo object. Besides the
+// types supported by objects.FromInterface it converts structs
+// (by their json tag names), typed maps and slices, pointers and
+// errors. This can be used inside of extension functions to
+// return go values to the scripts.
+func ToObject(v interface{}) (objects.Object, error) {
+	switch v := v.(type) {
+	case nil:
+		return objects.UndefinedValue, nil
+	case objects.Object:
+		return v, nil
+	case error:
+		return ToError(v), nil
+	case time.Time:
+		return &objects.Time{Value: v}, nil
+	case []byte:
+		return &objects.Bytes{Value: v}, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return &objects.String{Value: rv.String()}, nil
+	case reflect.Bool:
+		if rv.Bool() {
+			return objects.TrueValue, nil
+		}
+		return objects.FalseValue, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &objects.Int{Value: rv.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &objects.Int{Value: int64(rv.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &objects.Float{Value: rv.Float()}, nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return objects.UndefinedValue, nil
+		}
+		return ToObject(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		arr := &objects.Array{Value: make([]objects.Object, 0, rv.Len())}
+		for i := 0; i < rv.Len(); i++ {
+			o, err := ToObject(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			arr.Value = append(arr.Value, o)
+		}
+		return arr, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, errors.Errorf("unsupported map key type %s", rv.Type().Key())
+		}
+		m := &objects.Map{Value: make(map[string]objects.Object, rv.Len())}
+		iter := rv.MapRange()
+		for iter.Next() {
+			o, err := ToObject(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			m.Value[iter.Key().String()] = o
+		}
+		return m, nil
+	case reflect.Struct:
+		m := &objects.Map{Value: make(map[string]objects.Object, rv.NumField())}
+		for i := 0; i < rv.NumField(); i++ {
+			field := rv.Type().Field(i)
+			if len(field.PkgPath) > 0 {
+				continue
+			}
+
+			name := field.Name
+			if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+				continue
+			} else if len(tag) > 0 {
+				name = tag
+			}
+
+			o, err := ToObject(rv.Field(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			m.Value[name] = o
+		}
+		return m, nil
+	}
+
+	return nil, errors.Errorf("unsupported type %T", v)
+}
@@ -0,0 +1,276 @@
+package why
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/d5/tengo/objects"
+)
+
+type bindPoint struct {
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	Name string `json:"name,omitempty"`
+	Skip string `json:"-"`
+	priv string
+}
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		name    string
+		fn      interface{}
+		args    []objects.Object
+		want    interface{}
+		wantErr bool
+		isError bool
+	}{
+		{
+			name: "plain",
+			fn:   func(a, b int) int { return a + b },
+			args: []objects.Object{&objects.Int{Value: 1}, &objects.Int{Value: 2}},
+			want: int64(3),
+		},
+		{
+			name: "context and interop",
+			fn: func(ctx *RequestContext, interop objects.Interop, s string) string {
+				return s + "!"
+			},
+			args: []objects.Object{&objects.String{Value: "hi"}},
+			want: "hi!",
+		},
+		{
+			name: "variadic",
+			fn: func(sep string, values ...string) int {
+				return len(values)
+			},
+			args: []objects.Object{&objects.String{Value: ","}, &objects.String{Value: "a"}, &objects.String{Value: "b"}},
+			want: int64(2),
+		},
+		{
+			name: "no return value",
+			fn:   func(s string) {},
+			args: []objects.Object{&objects.String{Value: "a"}},
+			want: nil,
+		},
+		{
+			name:    "returned error",
+			fn:      func() (int, error) { return 0, errors.New("failed") },
+			isError: true,
+		},
+		{
+			name: "struct argument",
+			fn:   func(p bindPoint) int { return p.X * p.Y },
+			args: []objects.Object{&objects.Map{Value: map[string]objects.Object{
+				"x": &objects.Int{Value: 2},
+				"y": &objects.Int{Value: 3},
+			}}},
+			want: int64(6),
+		},
+		{
+			name: "object argument",
+			fn:   func(o objects.Object) objects.Object { return o },
+			args: []objects.Object{&objects.String{Value: "raw"}},
+			want: "raw",
+		},
+		{
+			name:    "too few arguments",
+			fn:      func(a, b int) int { return a + b },
+			args:    []objects.Object{&objects.Int{Value: 1}},
+			wantErr: true,
+		},
+		{
+			name:    "too few variadic arguments",
+			fn:      func(sep string, values ...string) {},
+			wantErr: true,
+		},
+		{
+			name:    "wrong type",
+			fn:      func(a int) int { return a },
+			args:    []objects.Object{&objects.String{Value: "abc"}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn, err := Bind(test.fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ret, err := fn(nil, nil, test.args...)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.isError {
+				if _, ok := ret.(*objects.Error); !ok {
+					t.Fatalf("expected error object, got %v", ret)
+				}
+				return
+			}
+
+			if ret == nil {
+				if test.want != nil {
+					t.Fatalf("expected %v, got nil", test.want)
+				}
+				return
+			}
+			if got := objects.ToInterface(ret); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("expected %#v, got %#v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestBindInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   interface{}
+	}{
+		{name: "not a function", fn: 10},
+		{name: "too many return values", fn: func() (int, int, error) { return 0, 0, nil }},
+		{name: "second return value not a error", fn: func() (int, int) { return 0, 0 }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Bind(test.fn); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	now := time.Unix(1560000000, 0)
+
+	tests := []struct {
+		name    string
+		obj     objects.Object
+		typ     reflect.Type
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "string", obj: &objects.String{Value: "a"}, typ: reflect.TypeOf(""), want: "a"},
+		{name: "int", obj: &objects.Int{Value: 5}, typ: reflect.TypeOf(0), want: 5},
+		{name: "int8", obj: &objects.Int{Value: 5}, typ: reflect.TypeOf(int8(0)), want: int8(5)},
+		{name: "uint", obj: &objects.Int{Value: 5}, typ: reflect.TypeOf(uint(0)), want: uint(5)},
+		{name: "negative uint", obj: &objects.Int{Value: -1}, typ: reflect.TypeOf(uint(0)), wantErr: true},
+		{name: "float", obj: &objects.Float{Value: 1.5}, typ: reflect.TypeOf(0.0), want: 1.5},
+		{name: "bool", obj: objects.TrueValue, typ: reflect.TypeOf(false), want: true},
+		{name: "false", obj: objects.FalseValue, typ: reflect.TypeOf(false), want: false},
+		{name: "not a bool", obj: &objects.String{Value: "x"}, typ: reflect.TypeOf(false), wantErr: true},
+		{name: "int as bool", obj: &objects.Int{Value: 1}, typ: reflect.TypeOf(false), wantErr: true},
+		{name: "undefined as bool", obj: objects.UndefinedValue, typ: reflect.TypeOf(false), wantErr: true},
+		{name: "time", obj: &objects.Time{Value: now}, typ: reflect.TypeOf(time.Time{}), want: now},
+		{name: "bytes", obj: &objects.Bytes{Value: []byte("ab")}, typ: reflect.TypeOf([]byte(nil)), want: []byte("ab")},
+		{name: "interface", obj: &objects.Int{Value: 5}, typ: reflect.TypeOf((*interface{})(nil)).Elem(), want: int64(5)},
+		{name: "undefined interface", obj: objects.UndefinedValue, typ: reflect.TypeOf((*interface{})(nil)).Elem(), want: nil},
+		{name: "not a int", obj: &objects.String{Value: "x"}, typ: reflect.TypeOf(0), wantErr: true},
+		{name: "not a time", obj: &objects.String{Value: "x"}, typ: reflect.TypeOf(time.Time{}), wantErr: true},
+		{
+			name: "slice",
+			obj:  &objects.Array{Value: []objects.Object{&objects.String{Value: "a"}, &objects.String{Value: "b"}}},
+			typ:  reflect.TypeOf([]string(nil)),
+			want: []string{"a", "b"},
+		},
+		{
+			name: "map",
+			obj:  &objects.Map{Value: map[string]objects.Object{"a": &objects.Int{Value: 1}}},
+			typ:  reflect.TypeOf(map[string]int(nil)),
+			want: map[string]int{"a": 1},
+		},
+		{
+			name: "struct",
+			obj:  &objects.Map{Value: map[string]objects.Object{"x": &objects.Int{Value: 1}, "name": &objects.String{Value: "p"}}},
+			typ:  reflect.TypeOf(bindPoint{}),
+			want: bindPoint{X: 1, Name: "p"},
+		},
+		{name: "unsupported interface", obj: &objects.Int{Value: 1}, typ: reflect.TypeOf((*error)(nil)).Elem(), wantErr: true},
+		{name: "unsupported kind", obj: &objects.Int{Value: 1}, typ: reflect.TypeOf(make(chan int)), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := fromObject(test.obj, test.typ)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got.Interface(), test.want) {
+				t.Fatalf("expected %#v, got %#v", test.want, got.Interface())
+			}
+		})
+	}
+}
+
+func TestToObject(t *testing.T) {
+	name := "p"
+
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "nil", value: nil, want: nil},
+		{name: "string", value: "a", want: "a"},
+		{name: "int", value: 5, want: int64(5)},
+		{name: "uint", value: uint8(5), want: int64(5)},
+		{name: "float", value: float32(1.5), want: 1.5},
+		{name: "bool", value: true, want: true},
+		{name: "bytes", value: []byte("ab"), want: []byte("ab")},
+		{name: "pointer", value: &name, want: "p"},
+		{name: "nil pointer", value: (*string)(nil), want: nil},
+		{name: "slice", value: []int{1, 2}, want: []interface{}{int64(1), int64(2)}},
+		{name: "map", value: map[string]bool{"a": true}, want: map[string]interface{}{"a": true}},
+		{
+			name:  "struct",
+			value: bindPoint{X: 1, Y: 2, Skip: "s", priv: "p"},
+			want:  map[string]interface{}{"x": int64(1), "y": int64(2), "name": ""},
+		},
+		{name: "unsupported map key", value: map[int]string{1: "a"}, wantErr: true},
+		{name: "unsupported type", value: make(chan int), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ToObject(test.value)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := objects.ToInterface(got); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("expected %#v, got %#v", test.want, got)
+			}
+		})
+	}
+
+	if o, _ := ToObject(errors.New("failed")); o.TypeName() != "error" {
+		t.Fatalf("expected error object, got %v", o)
+	}
+	if o, _ := ToObject(time.Unix(0, 0)); o.TypeName() != "time" {
+		t.Fatalf("expected time object, got %v", o)
+	}
+}
@@ -1,16 +1,31 @@
 package why
 
 import (
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/cespare/xxhash"
 	"github.com/d5/tengo/script"
+	"go.uber.org/atomic"
 )
 
 type (
 	cacheEntry struct {
-		base *script.Compiled
-		refs *sync.Pool
+		path     string
+		compiled time.Time
+		hits     *atomic.Int64
+		base     *script.Compiled
+		refs     *sync.Pool
+	}
+
+	// cachedScript describes a entry of the cache.
+	cachedScript struct {
+		Hash     string    `json:"hash"`
+		Path     string    `json:"path"`
+		Compiled time.Time `json:"compiled"`
+		Hits     int64     `json:"hits"`
 	}
 
 	scriptSetupFunc func(sc *script.Script)
@@ -19,6 +34,8 @@ type (
 		mtx         sync.RWMutex
 		cache       map[uint64]cacheEntry
 		setupScript scriptSetupFunc
+		created     *atomic.Int64
+		inUse       *atomic.Int64
 	}
 )
 
@@ -26,10 +43,25 @@ func newCache(setupFunc scriptSetupFunc) *scriptCache {
 	return &scriptCache{
 		cache:       map[uint64]cacheEntry{},
 		setupScript: setupFunc,
+		created:     atomic.NewInt64(0),
+		inUse:       atomic.NewInt64(0),
+	}
+}
+
+// get returns a instance of the compiled script and if it was
+// found in the cache.
+func (sc *scriptCache) get(scriptPath string, scriptSource []byte) (uint64, *script.Compiled, bool, error) {
+	if hashSum, compiled, ok := sc.lookup(scriptSource); ok {
+		return hashSum, compiled, true, nil
 	}
+
+	hashSum, compiled, err := sc.store(scriptPath, scriptSource)
+	return hashSum, compiled, false, err
 }
 
-func (sc *scriptCache) get(scriptSource []byte) (uint64, *script.Compiled, error) {
+// lookup returns a instance of the compiled script if the
+// script is cached.
+func (sc *scriptCache) lookup(scriptSource []byte) (uint64, *script.Compiled, bool) {
 	// Calculate a uint64 hash of the source. Map access with integers
 	// is faster than with strings so we use a hash algorithm that outputs
 	// uint64 values.
@@ -37,47 +69,118 @@ func (sc *scriptCache) get(scriptSource []byte) (uint64, *script.Compiled, error
 
 	// Check if script is cached by looking up the hash
 	sc.mtx.RLock()
+	defer sc.mtx.RUnlock()
+
 	entry, ok := sc.cache[hashSum]
-	if ok {
-		// Script is cached and we can return a clone of the compiled script.
-		defer sc.mtx.RUnlock()
-		return hashSum, entry.refs.Get().(*script.Compiled), nil
+	if !ok {
+		return hashSum, nil, false
 	}
-	sc.mtx.RUnlock()
 
-	// Script was never compiled before.
+	// Script is cached and we can return a clone of the compiled script.
+	entry.hits.Inc()
+	sc.inUse.Inc()
+	return hashSum, entry.refs.Get().(*script.Compiled), true
+}
+
+// store compiles the script, adds it to the cache and returns
+// a instance of it. The path is only used to describe the entry.
+func (sc *scriptCache) store(scriptPath string, scriptSource []byte) (uint64, *script.Compiled, error) {
+	hashSum := xxhash.Sum64(scriptSource)
+
 	sc.mtx.Lock()
 	defer sc.mtx.Unlock()
 
-	// Create script and setup all the variables, imports etc.
-	s := script.New(scriptSource)
-	sc.setupScript(s)
-
 	// Compile the script and check for any errors.
-	compiled, err := s.Compile()
+	compiled, err := sc.compile(scriptSource)
 	if err != nil {
 		return 0, nil, err
 	}
 
+	// If the script defines method handlers the dispatch call needs
+	// to be appended and the script compiled again.
+	if trailer := dispatchTrailer(compiled); trailer != nil {
+		source := make([]byte, 0, len(scriptSource)+len(trailer))
+		source = append(append(source, scriptSource...), trailer...)
+
+		compiled, err = sc.compile(source)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
 	// Create a pool that will clone the compiled script to create
 	// new instances.
 	refs := &sync.Pool{
 		New: func() interface{} {
+			sc.created.Inc()
 			return compiled.Clone()
 		},
 	}
 
 	// Set the cache entry.
 	sc.cache[hashSum] = cacheEntry{
-		base: compiled,
-		refs: refs,
+		path:     scriptPath,
+		compiled: time.Now(),
+		hits:     atomic.NewInt64(0),
+		base:     compiled,
+		refs:     refs,
 	}
 
+	sc.inUse.Inc()
 	return hashSum, refs.Get().(*script.Compiled), nil
 }
 
+func (sc *scriptCache) compile(scriptSource []byte) (*script.Compiled, error) {
+	// Create script and setup all the variables, imports etc.
+	s := script.New(scriptSource)
+	sc.setupScript(s)
+	return s.Compile()
+}
+
 func (sc *scriptCache) put(hashSum uint64, compiled *script.Compiled) {
 	sc.mtx.RLock()
-	sc.cache[hashSum].refs.Put(compiled)
+	// The entry could have been purged while the instance was in use.
+	if entry, ok := sc.cache[hashSum]; ok {
+		entry.refs.Put(compiled)
+	}
+	sc.mtx.RUnlock()
+	sc.inUse.Dec()
+}
+
+func (sc *scriptCache) len() int {
+	sc.mtx.RLock()
+	defer sc.mtx.RUnlock()
+	return len(sc.cache)
+}
+
+// list returns a description of all cached scripts sorted by path.
+func (sc *scriptCache) list() []cachedScript {
+	sc.mtx.RLock()
+	scripts := make([]cachedScript, 0, len(sc.cache))
+	for hashSum, entry := range sc.cache {
+		scripts = append(scripts, cachedScript{
+			Hash:     strconv.FormatUint(hashSum, 16),
+			Path:     entry.path,
+			Compiled: entry.compiled,
+			Hits:     entry.hits.Load(),
+		})
+	}
 	sc.mtx.RUnlock()
+
+	sort.Slice(scripts, func(i, j int) bool {
+		return scripts[i].Path < scripts[j].Path
+	})
+
+	return scripts
+}
+
+// purge removes all compiled scripts from the cache and
+// returns how many were removed.
+func (sc *scriptCache) purge() int {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	count := len(sc.cache)
+	sc.cache = map[uint64]cacheEntry{}
+	return count
 }
@@ -0,0 +1,67 @@
+package why
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ScriptError is a error of a script with the position it occurred at.
+// Line and Column are zero if the position is unknown.
+type ScriptError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ScriptError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Message)
+}
+
+// positionPattern matches the position of tengo errors (e.g. "at (main):3:5").
+var positionPattern = regexp.MustCompile(`\s*at \(main\):(\d+):(\d+)`)
+
+// newScriptError creates a ScriptError from a transpile or compile error.
+// The transpiler keeps the line breaks of the document, so the
+// line numbers of the tengo error match the .tengo file.
+func newScriptError(file string, err error) *ScriptError {
+	se := &ScriptError{File: file, Message: err.Error()}
+	if match := positionPattern.FindStringSubmatch(se.Message); match != nil {
+		se.Line, _ = strconv.Atoi(match[1])
+		se.Column, _ = strconv.Atoi(match[2])
+		se.Message = strings.TrimSpace(positionPattern.ReplaceAllString(se.Message, ""))
+	}
+	return se
+}
+
+// Check transpiles and compiles the script at the path (relative to
+// the PublicDir) without running it. Errors are returned as
+// *ScriptError with the position in the file if known.
+func (s *Server) Check(path string) error {
+	src, err := os.Open(filepath.Join(s.conf.PublicDir, path))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	transpiled := &bytes.Buffer{}
+	if err := Transpile(src, transpiled); err != nil {
+		return newScriptError(path, err)
+	}
+
+	back, sc, _, err := s.cache.get(path, transpiled.Bytes())
+	if err != nil {
+		return newScriptError(path, err)
+	}
+	s.cache.put(back, sc)
+
+	return nil
+}
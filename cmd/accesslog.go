@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/BigJk/why"
+)
+
+// setupAccessLog creates the access logger for the given target
+// ("stdout", "stderr" or a file path) and adds it to the server.
+// Log files will be reopened on SIGHUP so they can be rotated.
+func setupAccessLog(server *why.Server, target string, format string) error {
+	var w io.Writer
+	switch target {
+	case "":
+		return nil
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		file, err := why.OpenLogFile(target)
+		if err != nil {
+			return err
+		}
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := file.Reopen(); err != nil {
+					log.Printf("Error while reopening access log: %v\n", err)
+				}
+			}
+		}()
+
+		w = file
+	}
+
+	logger, err := why.NewAccessLog(w, format)
+	if err != nil {
+		return err
+	}
+
+	return server.SetAccessLogger(logger)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BigJk/why"
+)
+
+// runCheck transpiles and compiles all scripts in the
+// public dir and reports the errors with file and line.
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "config for the instance (.json, .yaml or .toml)")
+	_ = flags.Parse(args)
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while loading config: %v\n", err)
+		return 1
+	}
+
+	// The extensions are needed, because their variables and modules
+	// are declared in the compiled scripts. They are only declared, so
+	// no database is opened while a server is running. The server isn't
+	// shut down, because it was never started.
+	server, err := newServer(config, why.DeclareExtension)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	checked, failed := 0, 0
+	err = filepath.Walk(config.PublicDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !strings.HasSuffix(path, ".tengo") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(config.PublicDir, path)
+		if err != nil {
+			return err
+		}
+
+		checked++
+		if err := server.Check(rel); err != nil {
+			failed++
+			fmt.Println(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("%d scripts checked, %d with errors\n", checked, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/BigJk/why"
+)
+
+// envPrefix is the prefix of environment variables that
+// override config fields (e.g. WHY_PUBLIC_DIR).
+const envPrefix = "WHY_"
+
+// redacted replaces secret values when the config is printed.
+const redacted = "<redacted>"
+
+// Config is the configuration of the why binary.
+type Config struct {
+	why.Config
+
+	BindAddress     string
+	AccessLog       string
+	AccessLogFormat string
+	Extensions      map[string]json.RawMessage
+}
+
+// envPattern matches ${VAR} and ${VAR:-default}.
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// loadConfig reads the config file in JSON, YAML or TOML format
+// (chosen by the file extension), interpolates environment
+// variables and applies the WHY_* environment overrides.
+func loadConfig(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw interface{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		_, err = toml.Decode(string(data), &raw)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't parse '%s'", file)
+	}
+
+	raw, err = interpolate(normalize(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to JSON so all formats share the JSON
+	// unmarshalling of the config.
+	data, err = json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &Config{}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, errors.Wrapf(err, "invalid config '%s'", file)
+	}
+
+	if err := applyEnv(reflect.ValueOf(conf).Elem()); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// normalize converts the map[interface{}]interface{} of yaml
+// to map[string]interface{} so it can be encoded as JSON.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalize(value)
+		}
+		return m
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalize(value)
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+		return v
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = normalize(v[i])
+		}
+		return list
+	}
+	return v
+}
+
+// interpolate replaces ${VAR} and ${VAR:-default} in all string values
+// with the value of the environment variable. Variables that aren't
+// set and have no default result in a error.
+func interpolate(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			res, err := interpolate(value)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = res
+		}
+	case []interface{}:
+		for i := range v {
+			res, err := interpolate(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = res
+		}
+	case string:
+		var missing []string
+		res := envPattern.ReplaceAllStringFunc(v, func(match string) string {
+			groups := envPattern.FindStringSubmatch(match)
+			if value, ok := os.LookupEnv(groups[1]); ok {
+				return value
+			}
+			if strings.Contains(match, ":-") {
+				return groups[2]
+			}
+			missing = append(missing, groups[1])
+			return match
+		})
+		if len(missing) > 0 {
+			return nil, errors.Errorf("environment variable '%s' is not set", missing[0])
+		}
+		return res, nil
+	}
+	return v, nil
+}
+
+// applyEnv overrides the fields of the config with the WHY_* environment
+// variables. The name of the variable is the field name in upper snake
+// case (e.g. PublicDir can be set with WHY_PUBLIC_DIR). Values of non
+// string fields are parsed as JSON.
+func applyEnv(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := envPrefix + envName(field.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if field.Type.Kind() == reflect.String {
+			v.Field(i).SetString(value)
+			continue
+		}
+
+		target := v.Field(i).Addr().Interface()
+		if err := json.Unmarshal([]byte(value), target); err != nil {
+			// Allow unquoted strings for types like durations.
+			quoted, _ := json.Marshal(value)
+			if json.Unmarshal(quoted, target) != nil {
+				return errors.Errorf("invalid value of %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envName converts a field name to upper snake case
+// (e.g. TLSCertFile to TLS_CERT_FILE).
+func envName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// validateConfig checks the config including the extension configs
+// and returns the resolved config with redacted secrets.
+func validateConfig(conf *Config) (map[string]interface{}, error) {
+	if _, err := why.ParseLogLevel(conf.LogLevel); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved map[string]interface{}
+	if err := json.Unmarshal(data, &resolved); err != nil {
+		return nil, err
+	}
+
+	redact(resolved, reflect.TypeOf(conf.Config))
+
+	extensions := map[string]interface{}{}
+	for name, raw := range conf.Extensions {
+		typ, extConf, err := extensionType(name, raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "extension '%s'", name)
+		}
+
+		if err := why.ValidateExtensionConfig(typ, extConf); err != nil {
+			if name != typ {
+				err = errors.Wrapf(err, "instance '%s'", name)
+			}
+			return nil, err
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, err
+		}
+
+		f, _ := why.ExtensionFactoryByType(typ)
+		for _, field := range f.Schema() {
+			if field.Secret {
+				redactKey(fields, field.Name)
+			}
+		}
+
+		extensions[name] = fields
+	}
+	resolved["Extensions"] = extensions
+
+	return resolved, nil
+}
+
+// redact replaces the values of fields with a `secret:"true"` tag.
+func redact(m map[string]interface{}, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("secret") == "true" {
+			redactKey(m, t.Field(i).Name)
+		}
+	}
+}
+
+// redactKey replaces the non-empty value of the key, which is
+// matched case-insensitive like encoding/json does.
+func redactKey(m map[string]interface{}, name string) {
+	for key, value := range m {
+		if strings.EqualFold(key, name) && value != nil && value != "" {
+			m[key] = redacted
+		}
+	}
+}
+
+// runConfig runs the config sub commands. The only sub command
+// is 'validate' which prints the resolved config.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "Usage: why config validate [--config file]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "config for the instance (.json, .yaml or .toml)")
+	_ = flags.Parse(args[1:])
+
+	return printConfig(*configFile)
+}
+
+// printConfig validates the config file and prints the resolved config
+// with redacted secrets. It returns the exit code of the command.
+func printConfig(file string) int {
+	conf, err := loadConfig(file)
+	if err == nil {
+		var resolved map[string]interface{}
+		if resolved, err = validateConfig(conf); err == nil {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(resolved)
+			return 0
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+	return 1
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BigJk/why"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "PublicDir", want: "PUBLIC_DIR"},
+		{name: "TLSCertFile", want: "TLS_CERT_FILE"},
+		{name: "BindAddress", want: "BIND_ADDRESS"},
+		{name: "HTTP2", want: "HTTP2"},
+		{name: "MaxBodySize", want: "MAX_BODY_SIZE"},
+		{name: "Disable2FA", want: "DISABLE2_FA"},
+		{name: "X", want: "X"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := envName(test.name); got != test.want {
+				t.Fatalf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	os.Setenv("WHY_TEST_HOST", "example.com")
+	os.Setenv("WHY_TEST_EMPTY", "")
+	defer os.Unsetenv("WHY_TEST_HOST")
+	defer os.Unsetenv("WHY_TEST_EMPTY")
+
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "plain", value: "text", want: "text"},
+		{name: "variable", value: "https://${WHY_TEST_HOST}/", want: "https://example.com/"},
+		{name: "default", value: "${WHY_TEST_MISSING:-fallback}", want: "fallback"},
+		{name: "empty default", value: "a${WHY_TEST_MISSING:-}b", want: "ab"},
+		{name: "set but empty", value: "${WHY_TEST_EMPTY:-fallback}", want: ""},
+		{name: "missing", value: "${WHY_TEST_MISSING}", wantErr: true},
+		{name: "not a variable", value: "$WHY_TEST_HOST", want: "$WHY_TEST_HOST"},
+		{name: "number", value: json.Number("1"), want: json.Number("1")},
+		{
+			name:  "nested",
+			value: map[string]interface{}{"a": []interface{}{"${WHY_TEST_HOST}", true}},
+			want:  map[string]interface{}{"a": []interface{}{"example.com", true}},
+		},
+		{name: "nested missing", value: []interface{}{"${WHY_TEST_MISSING}"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := interpolate(test.value)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("expected %#v, got %#v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(c *Config) bool
+		wantErr bool
+	}{
+		{
+			name:  "string",
+			env:   map[string]string{"WHY_PUBLIC_DIR": "./www"},
+			check: func(c *Config) bool { return c.PublicDir == "./www" },
+		},
+		{
+			name:  "outer field",
+			env:   map[string]string{"WHY_BIND_ADDRESS": ":9000"},
+			check: func(c *Config) bool { return c.BindAddress == ":9000" },
+		},
+		{
+			name:  "bool",
+			env:   map[string]string{"WHY_ENABLE_ERROR": "true"},
+			check: func(c *Config) bool { return c.EnableError },
+		},
+		{
+			name:  "unquoted duration",
+			env:   map[string]string{"WHY_READ_TIMEOUT": "5s"},
+			check: func(c *Config) bool { return c.ReadTimeout == why.Duration(5*time.Second) },
+		},
+		{
+			name:  "json",
+			env:   map[string]string{"WHY_APP_ENV": `["HOME", "USER"]`},
+			check: func(c *Config) bool { return reflect.DeepEqual(c.AppEnv, []string{"HOME", "USER"}) },
+		},
+		{
+			name:    "invalid",
+			env:     map[string]string{"WHY_ENABLE_ERROR": "maybe"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				os.Setenv(key, value)
+				defer os.Unsetenv(key)
+			}
+
+			conf := &Config{}
+			err := applyEnv(reflect.ValueOf(conf).Elem())
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.check(conf) {
+				t.Fatalf("unexpected config %+v", conf)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/BigJk/why"
+)
+
+// runExport renders all pages into a static site and writes a
+// manifest with the exported pages and the failures.
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "config for the instance (.json, .yaml or .toml)")
+	out := flags.String("out", "./dist", "output directory")
+	manifestFile := flags.String("manifest", "./export-manifest.json", "file the manifest of pages and failures is written to")
+	_ = flags.Parse(args)
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while loading config: %v\n", err)
+		return 1
+	}
+
+	// The debug toolbar should never end up in the exported pages.
+	config.DebugToolbar = false
+
+	server, err := newServer(config, why.CreateExtension)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer server.Shutdown()
+
+	manifest, err := server.Export(*out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := ioutil.WriteFile(*manifestFile, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("%d pages and %d assets exported to %s\n", len(manifest.Pages), len(manifest.Assets), *out)
+	for _, failure := range manifest.Failures {
+		fmt.Printf("failed: %s (%d) %s\n", failure.Path, failure.Status, failure.Error)
+	}
+
+	if len(manifest.Failures) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BigJk/why"
+	"github.com/pkg/errors"
+)
+
+// extensionType returns the type of a configured extension instance
+// and its config without the "Type" field. If no type is set the
+// instance name is used as type, so a single instance can simply be
+// configured as "bbolt": { ... }.
+func extensionType(name string, conf json.RawMessage) (string, json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(conf)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return name, conf, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &fields); err != nil {
+		return "", nil, err
+	}
+
+	for key, value := range fields {
+		if !strings.EqualFold(key, "type") {
+			continue
+		}
+
+		var typ string
+		if err := json.Unmarshal(value, &typ); err != nil {
+			return "", nil, errors.New("field 'Type' needs to be a string")
+		}
+
+		delete(fields, key)
+
+		rest, err := json.Marshal(fields)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return typ, rest, nil
+	}
+
+	return name, conf, nil
+}
+
+// runExtensions lists the available extensions.
+func runExtensions(args []string) int {
+	printExtensions(os.Stdout)
+	return 0
+}
+
+// printExtensions writes the available extensions and
+// the fields of their config to w.
+func printExtensions(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	for i, f := range why.ExtensionFactories() {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+
+		fmt.Fprintf(tw, "%s - %s\n", f.Type, f.Description)
+		for _, field := range f.Schema() {
+			var details string
+			switch {
+			case field.Required:
+				details = "required"
+			case len(field.Default) > 0:
+				details = "default " + field.Default
+			}
+
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", field.Name, field.Type, details, field.Help)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// scaffold contains the files created by 'why init'.
+var scaffold = []struct {
+	path    string
+	content string
+}{
+	{"config.json", `{
+  "PublicDir": "./public",
+  "BindAddress": ":8765",
+  "EnableError": true,
+  "Extensions": {}
+}
+`},
+	{"public/index.tengo", `<!DOCTYPE html>
+<html lang="en">
+  <title>why</title>
+  <body>
+
+    <!?
+
+      http.write("Get Parameter:<br>")
+      keys := http.GET.keys()
+      for i := 0; i < len(keys); i++ {
+          http.write("name=", http.escape(keys[i]), ", value=", http.escape(http.GET.param(keys[i])), "<br>")
+      }
+
+    ?!>
+
+  </body>
+</html>
+`},
+}
+
+// runInit creates a new project in the given directory
+// (default is the working directory). Existing files are
+// never overwritten.
+func runInit(args []string) int {
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	for _, file := range scaffold {
+		if _, err := os.Stat(filepath.Join(dir, file.path)); err == nil {
+			fmt.Fprintf(os.Stderr, "'%s' already exists\n", filepath.Join(dir, file.path))
+			return 1
+		}
+	}
+
+	for _, file := range scaffold {
+		path := filepath.Join(dir, file.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if err := ioutil.WriteFile(path, []byte(file.content), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		fmt.Printf("created %s\n", path)
+	}
+
+	// The paths of the config are relative to the working
+	// directory, so the server needs to be started in dir.
+	if dir != "." {
+		fmt.Printf("\nStart the server with 'cd %s && why serve --dev'\n", dir)
+	} else {
+		fmt.Println("\nStart the server with 'why serve --dev'")
+	}
+	return 0
+}
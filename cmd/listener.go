@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// listenFdsStart is the first file descriptor passed by
+// systemd socket activation.
+const listenFdsStart = 3
+
+// inheritedListener returns the first listener passed via systemd
+// socket activation (LISTEN_FDS). If no listener was passed to this
+// process nil is returned.
+func inheritedListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+
+	// Don't pass the variables to child processes.
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(uintptr(listenFdsStart), "LISTEN_FD_"+strconv.Itoa(listenFdsStart))
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "error while using inherited listener")
+	}
+
+	return listener, nil
+}
+
+// listen creates the listener for the bind address. Inherited
+// listeners take precedence over the address. Addresses with
+// a "unix:" prefix will listen on a unix socket.
+func listen(address string) (net.Listener, error) {
+	listener, err := inheritedListener()
+	if err != nil || listener != nil {
+		return listener, err
+	}
+
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+
+		// Remove a stale socket of a previous run.
+		if stat, err := os.Stat(path); err == nil && stat.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(path)
+		}
+
+		return net.Listen("unix", path)
+	}
+
+	return net.Listen("tcp", address)
+}
@@ -1,169 +1,63 @@
 package main
 
 import (
-	"encoding/json"
-	"flag"
-	"io/ioutil"
-	"log"
+	"fmt"
 	"os"
-	"os/signal"
-	"reflect"
+	"strings"
 
-	"github.com/BigJk/why/extensions/jwt"
-
-	"github.com/mitchellh/mapstructure"
-	"github.com/pkg/errors"
-
-	"github.com/BigJk/why"
-	"github.com/BigJk/why/extensions/bbolt"
+	// Register the available extensions.
+	_ "github.com/BigJk/why/extensions/bbolt"
+	_ "github.com/BigJk/why/extensions/jwt"
 )
 
-var config = struct {
-	why.Config
-
-	BindAddress string
-	Extensions  map[string][]interface{}
-}{}
-
-var extensions = map[string]interface{}{
-	"bbolt": bbolt.New,
-	"jwt":   jwt.New,
+// command is a sub command of the why binary. It
+// receives the arguments after the command name and
+// returns the exit code.
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(args []string) int
 }
 
-func tryCreate(fn interface{}, args []interface{}) (why.Extension, error) {
-	fnType := reflect.TypeOf(fn)
-	fnValue := reflect.ValueOf(fn)
-
-	if fnType.Kind() != reflect.Func {
-		return nil, errors.New("fn wasn't a function")
-	}
-
-	if fnType.NumIn() != len(args) {
-		return nil, errors.Errorf("arguments doesn't match. got=%d expected=%d", len(args), fnType.NumIn())
-	}
-
-	if fnType.Out(1).Kind() != reflect.Interface || !fnType.Out(1).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
-		return nil, errors.New("fn doesn't return a error as second value")
-	}
-
-	var callValues []reflect.Value
-	for i := range args {
-		argType := reflect.TypeOf(args[i])
-
-		if argType == nil {
-			switch fnType.In(i).Kind() {
-			case reflect.Ptr:
-				fallthrough
-			case reflect.Uintptr:
-				fallthrough
-			case reflect.Map:
-				fallthrough
-			case reflect.Slice:
-				callValues = append(callValues, reflect.New(fnType.In(i)).Elem())
-				continue
-			}
-
-			return nil, errors.Errorf("argument %d can't be null", i+1)
-		}
-
-		if fnType.In(i).Kind() == reflect.Struct && argType.Kind() == reflect.Map {
-			s := reflect.New(fnType.In(i))
-			if err := mapstructure.Decode(args[i], s.Interface()); err != nil {
-				return nil, err
-			}
-
-			callValues = append(callValues, s.Elem())
-			continue
-		}
-
-		if fnType.In(i).Kind() != argType.Kind() {
-			if argType.Kind() == reflect.Float64 {
-				switch fnType.In(i).Kind() {
-				case reflect.Int:
-					fallthrough
-				case reflect.Int8:
-					fallthrough
-				case reflect.Int16:
-					fallthrough
-				case reflect.Int32:
-					fallthrough
-				case reflect.Int64:
-					fallthrough
-				case reflect.Uint8:
-					fallthrough
-				case reflect.Uint16:
-					fallthrough
-				case reflect.Uint32:
-					fallthrough
-				case reflect.Uint64:
-					fallthrough
-				case reflect.Float32:
-					callValues = append(callValues, reflect.ValueOf(args[i]).Convert(fnType.In(i)))
-					continue
-				}
-			}
-
-			return nil, errors.Errorf("mismatching argument type of %d. argument. got=%s expected=%s", i+1, argType.Kind().String(), fnType.In(i).Kind().String())
-		}
-
-		callValues = append(callValues, reflect.ValueOf(args[i]))
-	}
-
-	res := fnValue.Call(callValues)
-	if res[1].Interface() != nil {
-		err := res[1].Interface().(error)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return res[0].Interface().(why.Extension), nil
+var commands = []command{
+	{"init", "init [dir]", "scaffold a new project with config and public dir", runInit},
+	{"serve", "serve [--config file] [--dev] [--bind address]", "run the server", runServe},
+	{"check", "check [--config file]", "transpile and compile all scripts and report errors", runCheck},
+	{"render", "render [--config file] [--method method] [--data body] [--header key:value] [--include] path?query", "execute a page offline and print the output", runRender},
+	{"export", "export [--config file] [--out dir] [--manifest file]", "export the pages as static site", runExport},
+	{"extensions", "extensions", "list the available extensions and their config", runExtensions},
+	{"config", "config validate [--config file]", "validate the config and print it with redacted secrets", runConfig},
 }
 
 func main() {
-	// --config flag to define the config file.
-	configFile := flag.String("config", "./config.json", "config for the instance")
-	flag.Parse()
-
-	// Read and unmarshal the config file.
-	data, err := ioutil.ReadFile(*configFile)
-	if err != nil {
-		panic(err)
-	}
+	args := os.Args[1:]
 
-	if err := json.Unmarshal(data, &config); err != nil {
-		panic(err)
+	// Without a command the server is started, so
+	// 'why --config file' keeps working.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		os.Exit(runServe(args))
 	}
 
-	// Create the why server instance.
-	server := why.New(&config.Config)
-
-	// Dynamically load the extensions.
-	for name, conf := range config.Extensions {
-		if ctor, ok := extensions[name]; ok {
-			ext, err := tryCreate(ctor, conf)
-			if err != nil {
-				log.Fatalf("Error while building extension: %v\n", err.Error())
-			} else {
-				if err := server.AddExtension(ext); err != nil {
-					log.Fatalf("Error while adding extension: %v\n", err.Error())
-				}
-			}
-		} else {
-			log.Fatalf("Extension '%s' not found\n", name)
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			os.Exit(cmd.run(args[1:]))
 		}
 	}
 
-	// Start the why server.
-	go server.Start(config.BindAddress)
-
-	// Wait for interrupt.
-	quit := make(chan os.Signal, 10)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+	printUsage()
+	if args[0] == "help" {
+		return
+	}
+	os.Exit(2)
+}
 
-	// Shut down server.
-	if err := server.Shutdown(); err != nil {
-		log.Printf("Error while shutting down server: %v\n", err)
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: why <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.description)
+		fmt.Fprintf(os.Stderr, "  %-12s why %s\n", "", cmd.usage)
 	}
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/BigJk/why"
+)
+
+// headerFlags collects repeated --header flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+// runRender executes a page with a synthetic request and prints the
+// response. The exit code is 1 if the page answered with a server error.
+func runRender(args []string) int {
+	var headers headerFlags
+
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "config for the instance (.json, .yaml or .toml)")
+	method := flags.String("method", http.MethodGet, "method of the request")
+	data := flags.String("data", "", "body of the request")
+	include := flags.Bool("include", false, "print the status and headers of the response")
+	flags.Var(&headers, "header", "header of the request as key:value (can be repeated)")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: why render [flags] path?query")
+		flags.PrintDefaults()
+		return 2
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while loading config: %v\n", err)
+		return 1
+	}
+
+	server, err := newServer(config, why.CreateExtension)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer server.Shutdown()
+
+	if err := server.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	target := renderTarget(config.BasePath, flags.Arg(0))
+
+	req := httptest.NewRequest(strings.ToUpper(*method), target, strings.NewReader(*data))
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			fmt.Fprintf(os.Stderr, "invalid header '%s'\n", header)
+			return 2
+		}
+		req.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	if len(*data) > 0 && len(req.Header.Get("Content-Type")) == 0 {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	if *include {
+		fmt.Printf("%s %d %s\n", req.Proto, rec.Code, http.StatusText(rec.Code))
+		_ = rec.Header().Write(os.Stdout)
+		fmt.Println()
+	}
+	_, _ = rec.Body.WriteTo(os.Stdout)
+
+	if rec.Code >= 500 {
+		return 1
+	}
+	return 0
+}
+
+// renderTarget returns the request target of the path, which is
+// relative to the BasePath like the paths of the exported pages.
+func renderTarget(basePath string, path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	if base := strings.Trim(basePath, "/"); len(base) > 0 {
+		return "/" + base + path
+	}
+	return path
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/BigJk/why"
+	"github.com/pkg/errors"
+)
+
+// runServe runs the server until it is interrupted or terminated.
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "config for the instance (.json, .yaml or .toml)")
+	dev := flags.Bool("dev", false, "enable error messages and the debug toolbar")
+	bind := flags.String("bind", "", "overrides the bind address of the config")
+	_ = flags.Parse(args)
+
+	// Read the config file.
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		log.Printf("Error while loading config: %v\n", err)
+		return 1
+	}
+
+	if *dev {
+		config.EnableError = true
+		config.DebugToolbar = true
+	}
+
+	if len(*bind) > 0 {
+		config.BindAddress = *bind
+	}
+
+	// Create the why server instance.
+	server, err := newServer(config, why.CreateExtension)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	if err := setupAccessLog(server, config.AccessLog, config.AccessLogFormat); err != nil {
+		log.Printf("Error while opening access log: %v\n", err)
+		return 1
+	}
+
+	// Create the listener and start the why server.
+	listener, err := listen(config.BindAddress)
+	if err != nil {
+		log.Printf("Error while binding '%s': %v\n", config.BindAddress, err)
+		_ = server.Shutdown()
+		return 1
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.StartListener(listener)
+	}()
+
+	// Wait for interrupt, termination or the server to stop.
+	quit := make(chan os.Signal, 10)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		// The server stopped on its own (e.g. the address is already
+		// in use), so only the extensions need to be shut down.
+		_ = server.Shutdown()
+		if err != nil {
+			log.Printf("Error while running server: %v\n", err)
+			return 1
+		}
+		return 0
+	case <-quit:
+	}
+
+	// Shut down server.
+	if err := server.Shutdown(); err != nil {
+		log.Printf("Error while shutting down server: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// newServer creates the server and the configured extensions with the
+// create function (e.g. why.CreateExtension).
+func newServer(config *Config, create func(typ string, conf json.RawMessage) (why.Extension, error)) (*why.Server, error) {
+	server := why.New(&config.Config)
+
+	// Only the created extensions need to be closed on errors,
+	// none of them was initialized yet.
+	var created []why.Extension
+	closeCreated := func() {
+		for i := len(created) - 1; i >= 0; i-- {
+			_ = created[i].Shutdown()
+		}
+	}
+
+	// Create the configured extensions in a stable order.
+	names := make([]string, 0, len(config.Extensions))
+	for name := range config.Extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		typ, conf, err := extensionType(name, config.Extensions[name])
+		if err != nil {
+			closeCreated()
+			return nil, errors.Wrapf(err, "error in config of extension '%s'", name)
+		}
+
+		ext, err := create(typ, conf)
+		if err != nil {
+			closeCreated()
+			return nil, errors.Wrapf(err, "error while building extension '%s'", name)
+		}
+		created = append(created, ext)
+
+		if err := server.AddExtensionAs(name, ext); err != nil {
+			closeCreated()
+			return nil, errors.Wrap(err, "error while adding extension")
+		}
+	}
+
+	return server, nil
+}
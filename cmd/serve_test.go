@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/BigJk/why"
+	"github.com/d5/tengo/script"
+)
+
+type fakeExtension struct {
+	name     string
+	Var      string
+	inited   bool
+	shutdown bool
+}
+
+func (e *fakeExtension) Name() string    { return e.name }
+func (e *fakeExtension) Init() error     { e.inited = true; return nil }
+func (e *fakeExtension) Shutdown() error { e.shutdown = true; return nil }
+func (e *fakeExtension) Vars() []string  { return []string{e.Var} }
+func (e *fakeExtension) Hook(sc *script.Compiled, w io.Writer, resp http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func TestNewServer(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions map[string]string
+		wantErr    bool
+		wantTypes  []string
+	}{
+		{
+			name:       "created in order",
+			extensions: map[string]string{"b": `{"Type": "fake", "Var": "b"}`, "a": `{"Type": "fake", "Var": "a"}`},
+			wantTypes:  []string{"fake", "fake"},
+		},
+		{
+			name:       "failing extension",
+			extensions: map[string]string{"a": `{"Type": "fake", "Var": "a"}`, "b": `{"Type": "broken"}`},
+			wantErr:    true,
+			wantTypes:  []string{"fake", "broken"},
+		},
+		{
+			name:       "variable clash",
+			extensions: map[string]string{"a": `{"Type": "fake", "Var": "a"}`, "b": `{"Type": "fake", "Var": "http"}`},
+			wantErr:    true,
+			wantTypes:  []string{"fake", "fake"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := &Config{Extensions: map[string]json.RawMessage{}}
+			for name, raw := range test.extensions {
+				conf.Extensions[name] = json.RawMessage(raw)
+			}
+
+			var types []string
+			var created []*fakeExtension
+			create := func(typ string, raw json.RawMessage) (why.Extension, error) {
+				types = append(types, typ)
+				if typ == "broken" {
+					return nil, errors.New("can't connect")
+				}
+				ext := &fakeExtension{name: "ext" + string(rune('0'+len(created)))}
+				if err := json.Unmarshal(raw, ext); err != nil {
+					return nil, err
+				}
+				created = append(created, ext)
+				return ext, nil
+			}
+
+			_, err := newServer(conf, create)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+
+			if len(types) != len(test.wantTypes) {
+				t.Fatalf("expected types %v, got %v", test.wantTypes, types)
+			}
+
+			for _, ext := range created {
+				if ext.inited {
+					t.Fatalf("expected %s not to be initialized", ext.name)
+				}
+				if ext.shutdown != test.wantErr {
+					t.Fatalf("expected shutdown of %s to be %v", ext.name, test.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderTarget(t *testing.T) {
+	tests := []struct {
+		basePath string
+		path     string
+		want     string
+	}{
+		{basePath: "", path: "/index", want: "/index"},
+		{basePath: "", path: "index?a=1", want: "/index?a=1"},
+		{basePath: "/app", path: "/index", want: "/app/index"},
+		{basePath: "app/", path: "index", want: "/app/index"},
+		{basePath: "/", path: "/index", want: "/index"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.basePath+test.path, func(t *testing.T) {
+			if got := renderTarget(test.basePath, test.path); got != test.want {
+				t.Fatalf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
@@ -1,7 +1,180 @@
 package why
 
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxBodySize is the maximum size of a request body
+// that will be read if no MaxBodySize is configured.
+const DefaultMaxBodySize = 10 << 20
+
+// DefaultShutdownTimeout is the time the server waits for running
+// requests on shutdown if no ShutdownTimeout is configured.
+const DefaultShutdownTimeout = time.Second * 5
+
 // Config represents the configuration of a why server.
 type Config struct {
 	PublicDir   string
 	EnableError bool
+
+	// DebugToolbar injects a overlay with timings, cache status,
+	// extension operations and variables into html responses. It
+	// only works in dev mode, so EnableError needs to be true too.
+	DebugToolbar bool
+
+	// BasePath is the path prefix the server is mounted under
+	// (e.g. "/app"). The prefix will be stripped from the request
+	// path before resolving scripts and requests outside of it
+	// will be answered with 404.
+	BasePath string
+
+	// ModuleExtensions makes extensions that can be imported as
+	// module (e.g. db := import("bbolt")) only available as module
+	// instead of also adding a global variable for them.
+	ModuleExtensions bool
+
+	// AppConfig is available to the scripts as read-only CONFIG map
+	// of the app module (e.g. for feature flags, site titles or api
+	// urls).
+	AppConfig map[string]interface{}
+
+	// AppEnv is the allowlist of environment variables that are
+	// available to the scripts in the read-only ENV map of the app
+	// module. Entries ending with "*" allow all variables with the
+	// prefix.
+	AppEnv []string
+
+	// MaxBodySize is the maximum size of a request body in bytes.
+	// Requests with a bigger body will be answered with 413. If
+	// zero DefaultMaxBodySize will be used.
+	MaxBodySize int64
+
+	// LogLevel is the minimum level (debug, info, warn or error) of
+	// messages logged by scripts. Defaults to info.
+	LogLevel string
+
+	// TLSCertFile and TLSKeyFile enable TLS if both are set. The
+	// files are checked for changes and the certificate will be reloaded if
+	// they change, so renewed certificates don't need a restart.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// DisableHTTP2 disables HTTP/2 which is otherwise negotiated
+	// automatically for TLS connections.
+	DisableHTTP2 bool
+
+	// RedirectAddress starts a additional plain HTTP listener on the
+	// address that redirects all requests to HTTPS. Only used if TLS
+	// is enabled.
+	RedirectAddress string
+
+	// Timeouts and limits of the underlying http.Server. Zero values
+	// mean no timeout or the default of net/http.
+	ReadTimeout    Duration
+	WriteTimeout   Duration
+	IdleTimeout    Duration
+	MaxHeaderBytes int
+
+	// MetricsPath is the path of the Prometheus metrics endpoint
+	// (e.g. "/metrics"). The endpoint is disabled if empty.
+	MetricsPath string
+
+	// InternalAddress is the address of a separate listener for the
+	// metrics, health, readiness and admin endpoints. They aren't
+	// served on the main listener unless ExposeInternal is set.
+	InternalAddress string
+
+	// ExposeInternal additionally serves the internal endpoints on
+	// the main listener. They are routed after the BasePath is
+	// stripped and after the middlewares, so middlewares can
+	// protect them.
+	ExposeInternal bool
+
+	// HealthPath and ReadyPath enable the liveness and readiness
+	// endpoints (e.g. "/healthz" and "/readyz") if set.
+	HealthPath string
+	ReadyPath  string
+
+	// AdminPath enables the admin api under the path (e.g. "/admin").
+	// The api is only available if a AdminToken is set, which needs
+	// to be sent as bearer token.
+	AdminPath  string
+	AdminToken string `secret:"true"`
+
+	// PropagateTraceContext enables parsing of the W3C traceparent
+	// header. The trace context is available to tracers and the
+	// trace id is used as request id if no X-Request-ID was sent.
+	PropagateTraceContext bool
+
+	// ShutdownTimeout is the maximum time the server waits for
+	// running requests to finish on shutdown. If zero
+	// DefaultShutdownTimeout will be used.
+	ShutdownTimeout Duration
+}
+
+// Duration is a time.Duration that can be unmarshalled from
+// a duration string (e.g. "5s", "1m30s") or a number of
+// nanoseconds.
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string or a number.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(v)
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return errors.Errorf("invalid duration: %s", string(data))
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes the duration as duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (c *Config) maxBodySize() int64 {
+	if c.MaxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return c.MaxBodySize
+}
+
+func (c *Config) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeout <= 0 {
+		return DefaultShutdownTimeout
+	}
+	return time.Duration(c.ShutdownTimeout)
+}
+
+func (c *Config) basePath() string {
+	base := strings.TrimRight(c.BasePath, "/")
+	if len(base) > 0 && !strings.HasPrefix(base, "/") {
+		base = "/" + base
+	}
+	return base
+}
+
+func (c *Config) debugToolbar() bool {
+	return c.EnableError && c.DebugToolbar
+}
+
+func (c *Config) tlsEnabled() bool {
+	return len(c.TLSCertFile) > 0 && len(c.TLSKeyFile) > 0
 }
@@ -0,0 +1,224 @@
+package why
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/d5/tengo/script"
+)
+
+// maxDebugValueLength is the maximum length of a variable
+// value shown in the debug toolbar.
+const maxDebugValueLength = 200
+
+type debugDepthKey struct{}
+
+type debugSpan struct {
+	Name       string
+	Depth      int
+	Attributes map[string]interface{}
+	Start      time.Time
+	Duration   time.Duration
+	ended      bool
+	next       Span
+}
+
+func (ds *debugSpan) SetAttribute(key string, value interface{}) {
+	ds.Attributes[key] = value
+	ds.next.SetAttribute(key, value)
+}
+
+func (ds *debugSpan) End() {
+	ds.Duration = time.Since(ds.Start)
+	ds.ended = true
+	ds.next.End()
+}
+
+type debugEntry struct {
+	Category string
+	Message  string
+}
+
+type debugVariable struct {
+	Script string
+	Name   string
+	Type   string
+	Value  string
+}
+
+// debugInfo collects everything that is shown in the debug
+// toolbar of a request.
+type debugInfo struct {
+	mtx       sync.Mutex
+	start     time.Time
+	spans     []*debugSpan
+	entries   []debugEntry
+	variables []debugVariable
+}
+
+func newDebugInfo(start time.Time) *debugInfo {
+	return &debugInfo{start: start}
+}
+
+// startSpan records a span that wraps the span of the tracer.
+func (di *debugInfo) startSpan(ctx context.Context, name string, next Span) (context.Context, Span) {
+	depth, _ := ctx.Value(debugDepthKey{}).(int)
+
+	span := &debugSpan{
+		Name:       name,
+		Depth:      depth,
+		Attributes: map[string]interface{}{},
+		Start:      time.Now(),
+		next:       next,
+	}
+
+	di.mtx.Lock()
+	di.spans = append(di.spans, span)
+	di.mtx.Unlock()
+
+	return context.WithValue(ctx, debugDepthKey{}, depth+1), span
+}
+
+// recordVariables adds all global variables of the script that
+// weren't set by the server or extensions.
+func (di *debugInfo) recordVariables(scriptPath string, sc *script.Compiled, reserved map[string]bool) {
+	vars := sc.GetAll()
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].Name() < vars[j].Name()
+	})
+
+	di.mtx.Lock()
+	defer di.mtx.Unlock()
+
+	for _, v := range vars {
+		if reserved[v.Name()] {
+			continue
+		}
+
+		value := v.Object().String()
+		if len(value) > maxDebugValueLength {
+			value = value[:maxDebugValueLength] + "..."
+		}
+
+		di.variables = append(di.variables, debugVariable{
+			Script: scriptPath,
+			Name:   v.Name(),
+			Type:   v.ValueType(),
+			Value:  value,
+		})
+	}
+}
+
+// DebugRecord adds a entry to the debug toolbar of the request.
+// Extensions can use this to show what they did during a request
+// (e.g. database operations). Outside of dev mode, if the debug
+// toolbar is disabled or the request is nil this does nothing.
+func DebugRecord(r *http.Request, category string, message string) {
+	if r == nil {
+		return
+	}
+
+	info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	if !ok || info.debug == nil {
+		return
+	}
+
+	info.debug.mtx.Lock()
+	info.debug.entries = append(info.debug.entries, debugEntry{Category: category, Message: message})
+	info.debug.mtx.Unlock()
+}
+
+var debugToolbarTemplate = template.Must(template.New("toolbar").Parse(`
+<div id="why-debug" style="position:fixed;bottom:0;left:0;right:0;max-height:50%;overflow:auto;z-index:2147483647;background:#222;color:#eee;font:12px monospace;border-top:2px solid #f90">
+<details>
+<summary style="padding:4px 8px;cursor:pointer">why &middot; {{.Total}} &middot; status {{.Status}} &middot; cache {{.Cache}} &middot; request {{.RequestID}}</summary>
+<div style="padding:4px 8px">
+<b>Timing</b>
+<table>{{range .Spans}}<tr><td style="padding-left:{{.Indent}}px">{{.Name}}</td><td>{{.Duration}}</td><td>{{.Attributes}}</td></tr>{{end}}</table>
+{{if .Entries}}<b>Extensions</b>
+<table>{{range .Entries}}<tr><td>{{.Category}}</td><td>{{.Message}}</td></tr>{{end}}</table>{{end}}
+{{if .Variables}}<b>Variables</b>
+<table>{{range .Variables}}<tr><td>{{.Script}}</td><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.Value}}</td></tr>{{end}}</table>{{end}}
+</div>
+</details>
+</div>
+`))
+
+type debugSpanView struct {
+	Name       string
+	Indent     int
+	Duration   time.Duration
+	Attributes string
+}
+
+// isHTMLResponse checks if the toolbar can be injected into the
+// response. Responses without a content type are only treated as
+// html if they contain a closing body tag.
+func isHTMLResponse(contentType string, body []byte) bool {
+	if len(contentType) == 0 {
+		return bytes.Contains(body, []byte("</body>"))
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/html"
+}
+
+// inject renders the toolbar and inserts it before the closing
+// body tag or appends it if there is none. Spans that are still
+// running (e.g. the request itself) show the time elapsed so far.
+func (di *debugInfo) inject(buf *bytes.Buffer, requestID string, status int, cache string) {
+	di.mtx.Lock()
+	spans := make([]debugSpanView, len(di.spans))
+	for i, span := range di.spans {
+		attrs := ""
+		if len(span.Attributes) > 0 {
+			attrs = fmt.Sprint(span.Attributes)
+		}
+
+		duration := span.Duration
+		if !span.ended {
+			duration = time.Since(span.Start)
+		}
+
+		spans[i] = debugSpanView{
+			Name:       span.Name,
+			Indent:     span.Depth * 12,
+			Duration:   duration,
+			Attributes: attrs,
+		}
+	}
+
+	data := map[string]interface{}{
+		"Total":     time.Since(di.start),
+		"Status":    status,
+		"Cache":     cache,
+		"RequestID": requestID,
+		"Spans":     spans,
+		"Entries":   append([]debugEntry(nil), di.entries...),
+		"Variables": append([]debugVariable(nil), di.variables...),
+	}
+	di.mtx.Unlock()
+
+	var toolbar bytes.Buffer
+	if err := debugToolbarTemplate.Execute(&toolbar, data); err != nil {
+		return
+	}
+
+	body := buf.Bytes()
+	if i := bytes.LastIndex(body, []byte("</body>")); i >= 0 {
+		rest := append([]byte(nil), body[i:]...)
+		buf.Truncate(i)
+		buf.Write(toolbar.Bytes())
+		buf.Write(rest)
+		return
+	}
+
+	buf.Write(toolbar.Bytes())
+}
@@ -0,0 +1,106 @@
+package why
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsHTMLResponse(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        bool
+	}{
+		{contentType: "text/html", want: true},
+		{contentType: "text/html; charset=utf-8", want: true},
+		{contentType: "application/json", body: "</body>"},
+		{contentType: "text/plain; charset=utf-8"},
+		{contentType: "invalid;;", body: "</body>"},
+		{body: "<html><body></body></html>", want: true},
+		{body: `{"a": 1}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.contentType+" "+test.body, func(t *testing.T) {
+			if got := isHTMLResponse(test.contentType, []byte(test.body)); got != test.want {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestInject(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		before string
+		after  string
+	}{
+		{name: "closing body", body: "<html><body>page</body></html>", before: "<html><body>page", after: "</body></html>"},
+		{name: "last closing body", body: "<body></body>text</body>", before: "<body></body>text", after: "</body>"},
+		{name: "no closing body", body: "<p>page</p>", before: "<p>page</p>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			di := newDebugInfo(time.Now().Add(-time.Second))
+			_, _ = di.startSpan(context.Background(), "request", nopSpan{})
+
+			buf := bytes.NewBufferString(test.body)
+			di.inject(buf, "abc", http.StatusOK, "hit")
+
+			out := buf.String()
+			if !strings.HasPrefix(out, test.before) || !strings.HasSuffix(out, test.after) {
+				t.Fatalf("expected the toolbar between %q and %q, got %q", test.before, test.after, out)
+			}
+
+			toolbar := strings.TrimSuffix(strings.TrimPrefix(out, test.before), test.after)
+			if !strings.Contains(toolbar, `id="why-debug"`) || !strings.Contains(toolbar, "request abc") {
+				t.Fatalf("expected the toolbar, got %q", toolbar)
+			}
+
+			// The request span is still running and shows the elapsed time.
+			if strings.Contains(toolbar, "request</td><td>0s</td>") {
+				t.Fatalf("expected the running request span to show the elapsed time, got %q", toolbar)
+			}
+		})
+	}
+}
+
+func TestDebugToolbar(t *testing.T) {
+	dir, cleanup := publicDir(t, map[string]string{
+		"page.tengo": `<html><body><!? x := 1 ?!></body></html>`,
+		"api.tengo":  `<!? http.json_response({a: 1}) ?!>`,
+	})
+	defer cleanup()
+
+	s := New(&Config{PublicDir: dir, EnableError: true, DebugToolbar: true})
+
+	tests := []struct {
+		path    string
+		toolbar bool
+	}{
+		{path: "/page", toolbar: true},
+		{path: "/api"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			body := rec.Body.String()
+			if strings.Contains(body, `id="why-debug"`) != test.toolbar {
+				t.Fatalf("expected toolbar %v, got %q", test.toolbar, body)
+			}
+			if test.toolbar && strings.Contains(body, "request</td><td>0s</td>") {
+				t.Fatalf("expected the request time to be shown, got %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package why
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/d5/tengo/objects"
+	"github.com/d5/tengo/script"
+)
+
+// dispatchVariable is the name of the global function that the
+// dispatch trailer calls with the handlers of the script.
+const dispatchVariable = "__why_dispatch"
+
+// routesVariable is the name of a global route table that maps
+// methods to handler functions.
+const routesVariable = "ROUTES"
+
+// handlerMethods are the names of global functions that will be
+// treated as method handlers if a script defines them.
+var handlerMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// dispatchTrailer checks if the compiled script defines method handlers
+// or a route table and returns the source that needs to be appended to
+// the script so that the matching handler is called after the script
+// body ran. If the script doesn't define any handlers nil is returned.
+func dispatchTrailer(compiled *script.Compiled) []byte {
+	defined := map[string]bool{}
+	for _, v := range compiled.GetAll() {
+		defined[v.Name()] = true
+	}
+
+	return trailerSource(defined)
+}
+
+// trailerSource returns the dispatch trailer for the defined globals.
+func trailerSource(defined map[string]bool) []byte {
+	if defined[routesVariable] {
+		return []byte("\n" + dispatchVariable + "(" + routesVariable + ")\n")
+	}
+
+	var handlers []string
+	for i := range handlerMethods {
+		if defined[handlerMethods[i]] {
+			handlers = append(handlers, handlerMethods[i]+": "+handlerMethods[i])
+		}
+	}
+
+	if len(handlers) == 0 {
+		return nil
+	}
+
+	var trailer bytes.Buffer
+	trailer.WriteString("\n" + dispatchVariable + "({")
+	trailer.WriteString(strings.Join(handlers, ", "))
+	trailer.WriteString("})\n")
+	return trailer.Bytes()
+}
+
+// dispatch calls the handler matching the request method. If no handler
+// matches, OPTIONS requests will be answered with the allowed methods and
+// all other requests with 405. HEAD requests fall back to the GET handler.
+func dispatch(si *scriptInstance) objects.CallableFunc {
+	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		if len(args) != 1 {
+			return nil, objects.ErrWrongNumArguments
+		}
+
+		var routes map[string]objects.Object
+		switch table := args[0].(type) {
+		case *objects.Map:
+			routes = table.Value
+		case *objects.ImmutableMap:
+			routes = table.Value
+		default:
+			return nil, objects.ErrInvalidArgumentType{
+				Name:     "routes",
+				Expected: "map",
+				Found:    args[0].TypeName(),
+			}
+		}
+
+		handlers := map[string]objects.Object{}
+		for method, handler := range routes {
+			if handler != objects.UndefinedValue {
+				handlers[strings.ToUpper(method)] = handler
+			}
+		}
+
+		if _, ok := handlers[http.MethodHead]; !ok {
+			if get, ok := handlers[http.MethodGet]; ok {
+				handlers[http.MethodHead] = get
+			}
+		}
+
+		if handler, ok := handlers[si.req.Method]; ok {
+			return interop.InteropCall(handler)
+		}
+
+		allowed := []string{http.MethodOptions}
+		for method := range handlers {
+			if method != http.MethodOptions {
+				allowed = append(allowed, method)
+			}
+		}
+		sort.Strings(allowed)
+
+		si.respWriter.Header().Set("Allow", strings.Join(allowed, ", "))
+		si.buf.Reset()
+
+		if si.req.Method == http.MethodOptions {
+			*si.statusCode = http.StatusNoContent
+		} else {
+			*si.statusCode = http.StatusMethodNotAllowed
+		}
+
+		return nil, nil
+	}
+}
@@ -0,0 +1,93 @@
+package why
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/d5/tengo/objects"
+)
+
+// recordingInterop records the callable it was asked to call.
+type recordingInterop struct {
+	called objects.Object
+}
+
+func (i *recordingInterop) InteropCall(callable objects.Object, args ...objects.Object) (objects.Object, error) {
+	i.called = callable
+	return nil, nil
+}
+
+func TestTrailerSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		defined []string
+		want    string
+	}{
+		{name: "no handlers", defined: []string{"x", "get"}, want: ""},
+		{name: "single handler", defined: []string{"GET"}, want: "\n__why_dispatch({GET: GET})\n"},
+		{name: "ordered handlers", defined: []string{"DELETE", "POST", "GET"}, want: "\n__why_dispatch({GET: GET, POST: POST, DELETE: DELETE})\n"},
+		{name: "route table", defined: []string{"ROUTES", "GET"}, want: "\n__why_dispatch(ROUTES)\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defined := map[string]bool{}
+			for _, name := range test.defined {
+				defined[name] = true
+			}
+
+			if got := string(trailerSource(defined)); got != test.want {
+				t.Fatalf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	get := &objects.String{Value: "get"}
+	post := &objects.String{Value: "post"}
+	routes := &objects.Map{Value: map[string]objects.Object{
+		"get":  get,
+		"POST": post,
+		"PUT":  objects.UndefinedValue,
+	}}
+
+	tests := []struct {
+		method     string
+		wantCalled objects.Object
+		wantStatus int
+		wantAllow  string
+	}{
+		{method: http.MethodGet, wantCalled: get, wantStatus: http.StatusOK},
+		{method: http.MethodHead, wantCalled: get, wantStatus: http.StatusOK},
+		{method: http.MethodPost, wantCalled: post, wantStatus: http.StatusOK},
+		{method: http.MethodPut, wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET, HEAD, OPTIONS, POST"},
+		{method: http.MethodOptions, wantStatus: http.StatusNoContent, wantAllow: "GET, HEAD, OPTIONS, POST"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			si := newTestInstance(httptest.NewRequest(test.method, "/", nil))
+			si.buf.WriteString("body")
+
+			interop := &recordingInterop{}
+			if _, err := dispatch(si)(interop, routes); err != nil {
+				t.Fatal(err)
+			}
+
+			if interop.called != test.wantCalled {
+				t.Fatalf("expected handler %v, got %v", test.wantCalled, interop.called)
+			}
+			if *si.statusCode != test.wantStatus {
+				t.Fatalf("expected status %d, got %d", test.wantStatus, *si.statusCode)
+			}
+			if allow := si.respWriter.Header().Get("Allow"); allow != test.wantAllow {
+				t.Fatalf("expected Allow %q, got %q", test.wantAllow, allow)
+			}
+			if test.wantCalled == nil && si.buf.Len() > 0 {
+				t.Fatalf("expected the output to be discarded, got %q", si.buf.String())
+			}
+		})
+	}
+}
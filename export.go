@@ -0,0 +1,299 @@
+package why
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExportFailure describes a page or link that couldn't be exported.
+type ExportFailure struct {
+	Path   string `json:"path"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error"`
+}
+
+// ExportManifest is the result of a static export.
+type ExportManifest struct {
+	Pages    []string        `json:"pages"`
+	Assets   []string        `json:"assets"`
+	Failures []ExportFailure `json:"failures"`
+}
+
+// linkPattern matches the targets of href and src attributes.
+var linkPattern = regexp.MustCompile(`(?i)(?:href|src)\s*=\s*["']([^"']+)["']`)
+
+// Export renders all pages of the PublicDir into static files in the
+// output directory. Every script and every local link discovered in
+// the rendered html is requested with a GET request through ServeHTTP.
+// Html pages are written as "<path>.html", other responses as they are
+// and static files are copied. Local links to html pages are rewritten
+// to point to the written .html files. Pages that fail to render are
+// listed in the failures of the returned manifest. Query strings of
+// links are ignored, because they can't be represented by static files.
+func (s *Server) Export(outDir string) (*ExportManifest, error) {
+	if err := s.Init(); err != nil {
+		return nil, err
+	}
+
+	manifest := &ExportManifest{
+		Pages:    []string{},
+		Assets:   []string{},
+		Failures: []ExportFailure{},
+	}
+
+	// Copy the static files and collect the scripts.
+	var queue []string
+	err := filepath.Walk(s.conf.PublicDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.conf.PublicDir, file)
+		if err != nil {
+			return err
+		}
+		rel = "/" + filepath.ToSlash(rel)
+
+		switch {
+		case filepath.Base(rel) == middlewareFile:
+			// Middlewares only run as part of the pages.
+		case strings.HasSuffix(rel, ".tengo"):
+			queue = append(queue, strings.TrimSuffix(rel, ".tengo"))
+		default:
+			if err := copyFile(file, filepath.Join(outDir, filepath.FromSlash(rel))); err != nil {
+				return err
+			}
+			manifest.Assets = append(manifest.Assets, rel)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error while copying static files")
+	}
+
+	// Render the pages and the pages linked by them.
+	seen := map[string]bool{}
+	for _, p := range queue {
+		seen[p] = true
+	}
+
+	htmlPages := map[string]bool{}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		links, isHTML, err := s.exportPage(p, outDir)
+		if err != nil {
+			manifest.Failures = append(manifest.Failures, *err)
+			continue
+		}
+		manifest.Pages = append(manifest.Pages, p)
+		htmlPages[p] = isHTML
+
+		for _, link := range links {
+			if seen[link] {
+				continue
+			}
+			seen[link] = true
+
+			// Links to static files only need to be checked,
+			// because all static files are already copied.
+			if ext := path.Ext(link); len(ext) > 0 && ext != ".tengo" {
+				if _, err := os.Stat(filepath.Join(s.conf.PublicDir, filepath.FromSlash(link))); err != nil {
+					manifest.Failures = append(manifest.Failures, ExportFailure{Path: link, Status: http.StatusNotFound, Error: "broken link"})
+				}
+				continue
+			}
+
+			queue = append(queue, strings.TrimSuffix(link, ".tengo"))
+		}
+	}
+
+	// The links can only be rewritten after all pages are exported,
+	// because it's only known then which pages are html.
+	for p, isHTML := range htmlPages {
+		if !isHTML {
+			continue
+		}
+
+		target := filepath.Join(outDir, filepath.FromSlash(p)) + ".html"
+		data, err := ioutil.ReadFile(target)
+		if err == nil {
+			err = ioutil.WriteFile(target, s.rewriteLinks(p, data, htmlPages), 0644)
+		}
+		if err != nil {
+			manifest.Failures = append(manifest.Failures, ExportFailure{Path: p, Error: err.Error()})
+		}
+	}
+
+	sort.Strings(manifest.Pages)
+	sort.Strings(manifest.Assets)
+	sort.Slice(manifest.Failures, func(i, j int) bool {
+		return manifest.Failures[i].Path < manifest.Failures[j].Path
+	})
+
+	return manifest, nil
+}
+
+// exportPage renders the page at the path (relative to the BasePath),
+// writes it to the output directory and returns the local links of it
+// and if it was written as html page.
+func (s *Server) exportPage(p string, outDir string) ([]string, bool, *ExportFailure) {
+	base := s.conf.basePath()
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, base+p, nil))
+
+	// Redirects are followed by treating the target as link.
+	if rec.Code >= 300 && rec.Code < 400 {
+		if link, ok := s.localLink(base+p, rec.Header().Get("Location")); ok {
+			return []string{link}, false, nil
+		}
+		return nil, false, nil
+	}
+
+	if rec.Code != http.StatusOK {
+		return nil, false, &ExportFailure{Path: p, Status: rec.Code, Error: strings.TrimSpace(rec.Body.String())}
+	}
+
+	// Html pages are written as .html file so static file servers
+	// can serve them with the correct content type. Without a content
+	// type it is detected like net/http does for the served pages.
+	contentType := rec.Header().Get("Content-Type")
+	if len(contentType) == 0 {
+		contentType = http.DetectContentType(rec.Body.Bytes())
+	}
+
+	target := filepath.Join(outDir, filepath.FromSlash(p))
+	isHTML := isHTMLResponse(contentType, rec.Body.Bytes())
+	if isHTML {
+		target += ".html"
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, false, &ExportFailure{Path: p, Error: err.Error()}
+	}
+
+	if err := ioutil.WriteFile(target, rec.Body.Bytes(), 0644); err != nil {
+		return nil, false, &ExportFailure{Path: p, Error: err.Error()}
+	}
+
+	if !isHTML {
+		return nil, false, nil
+	}
+
+	var links []string
+	for _, match := range linkPattern.FindAllStringSubmatch(rec.Body.String(), -1) {
+		if link, ok := s.localLink(base+p, match[1]); ok {
+			links = append(links, link)
+		}
+	}
+
+	return links, true, nil
+}
+
+// rewriteLinks replaces the local links of the html page that point to
+// exported html pages with links to their .html files. The fragment of
+// the link is kept and the query string is dropped.
+func (s *Server) rewriteLinks(page string, data []byte, htmlPages map[string]bool) []byte {
+	base := s.conf.basePath()
+
+	var out bytes.Buffer
+	last := 0
+	for _, match := range linkPattern.FindAllSubmatchIndex(data, -1) {
+		start, end := match[2], match[3]
+		link := string(data[start:end])
+		if strings.HasPrefix(link, "#") {
+			continue
+		}
+
+		resolved, ok := s.localLink(base+page, link)
+		if !ok || !htmlPages[strings.TrimSuffix(resolved, ".tengo")] {
+			continue
+		}
+
+		rewritten := base + strings.TrimSuffix(resolved, ".tengo") + ".html"
+		if parsed, err := url.Parse(strings.TrimSpace(link)); err == nil && len(parsed.Fragment) > 0 {
+			rewritten += "#" + parsed.Fragment
+		}
+
+		out.Write(data[last:start])
+		out.WriteString(rewritten)
+		last = end
+	}
+	out.Write(data[last:])
+
+	return out.Bytes()
+}
+
+// localLink resolves the link relative to the page and returns the
+// path without the BasePath if it points to a page of this server.
+func (s *Server) localLink(page string, link string) (string, bool) {
+	target, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || len(target.Scheme) > 0 || len(target.Host) > 0 {
+		return "", false
+	}
+
+	resolved := (&url.URL{Path: page}).ResolveReference(target).Path
+	if len(resolved) == 0 || strings.Contains(resolved, "..") {
+		return "", false
+	}
+
+	base := s.conf.basePath()
+	if len(base) > 0 {
+		if resolved != base && !strings.HasPrefix(resolved, base+"/") {
+			return "", false
+		}
+		resolved = strings.TrimPrefix(resolved, base)
+	}
+
+	// Directories can't be exported, because scripts can only
+	// be requested by their name.
+	if len(resolved) == 0 || strings.HasSuffix(resolved, "/") {
+		return "", false
+	}
+
+	return resolved, true
+}
+
+// copyFile copies the file and creates the directories of the target.
+func copyFile(from string, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
@@ -0,0 +1,130 @@
+package why
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLocalLink(t *testing.T) {
+	tests := []struct {
+		base string
+		page string
+		link string
+		want string
+		ok   bool
+	}{
+		{page: "/index", link: "/about", want: "/about", ok: true},
+		{page: "/blog/post", link: "other", want: "/blog/other", ok: true},
+		{page: "/blog/post", link: "../about?x=1#top", want: "/about", ok: true},
+		{page: "/index", link: "style.css", want: "/style.css", ok: true},
+		{page: "/index", link: "https://example.com/about"},
+		{page: "/index", link: "//example.com/about"},
+		{page: "/index", link: "mailto:a@example.com"},
+		{page: "/index", link: "/blog/"},
+		{base: "/app", page: "/app/index", link: "/app/about", want: "/about", ok: true},
+		{base: "/app", page: "/app/blog/post", link: "other", want: "/blog/other", ok: true},
+		{base: "/app", page: "/app/index", link: "/about"},
+		{base: "/app", page: "/app/index", link: "/application"},
+		{base: "/app", page: "/app/index", link: "/app"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.base+" "+test.page+" "+test.link, func(t *testing.T) {
+			s := New(&Config{BasePath: test.base})
+			got, ok := s.localLink(test.page, test.link)
+			if ok != test.ok || got != test.want {
+				t.Fatalf("expected %q %v, got %q %v", test.want, test.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	pages := map[string]bool{"/index": true, "/about": true, "/blog/other": true, "/api": false}
+
+	tests := []struct {
+		base string
+		page string
+		html string
+		want string
+	}{
+		{
+			page: "/index",
+			html: `<a href="/about">A</a> <a href='about#team'>T</a> <a href="/api">J</a>`,
+			want: `<a href="/about.html">A</a> <a href='/about.html#team'>T</a> <a href="/api">J</a>`,
+		},
+		{
+			page: "/blog/post",
+			html: `<a href="other?page=2">O</a> <img src="/logo.png"> <a href="#top">Top</a>`,
+			want: `<a href="/blog/other.html">O</a> <img src="/logo.png"> <a href="#top">Top</a>`,
+		},
+		{
+			page: "/index",
+			html: `<a href="https://example.com/about">E</a> <a href="/missing">M</a>`,
+			want: `<a href="https://example.com/about">E</a> <a href="/missing">M</a>`,
+		},
+		{
+			base: "/app",
+			page: "/index",
+			html: `<a href="/app/about">A</a> <a href="about.tengo">T</a> <a href="/about">O</a>`,
+			want: `<a href="/app/about.html">A</a> <a href="/app/about.html">T</a> <a href="/about">O</a>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.base+test.page, func(t *testing.T) {
+			s := New(&Config{BasePath: test.base})
+			if got := string(s.rewriteLinks(test.page, []byte(test.html), pages)); got != test.want {
+				t.Fatalf("expected:\n%s\ngot:\n%s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestExportStatic(t *testing.T) {
+	for _, base := range []string{"", "/app"} {
+		t.Run(base, func(t *testing.T) {
+			public, err := ioutil.TempDir("", "why-public")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(public)
+
+			out, err := ioutil.TempDir("", "why-out")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(out)
+
+			if err := os.MkdirAll(filepath.Join(public, "css"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(public, "css", "style.css"), []byte("body{}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			s := New(&Config{PublicDir: public, BasePath: base})
+			manifest, err := s.Export(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(manifest.Assets, []string{"/css/style.css"}) {
+				t.Fatalf("unexpected assets %v", manifest.Assets)
+			}
+
+			data, err := ioutil.ReadFile(filepath.Join(out, "css", "style.css"))
+			if err != nil || string(data) != "body{}" {
+				t.Fatalf("expected the static file to be copied, got %q %v", data, err)
+			}
+
+			// Pages are requested under the BasePath.
+			if _, _, failure := s.exportPage("/css/style.css", out); failure != nil {
+				t.Fatalf("expected the page to be exported, got %+v", failure)
+			}
+		})
+	}
+}
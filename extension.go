@@ -4,6 +4,7 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/d5/tengo/objects"
 	"github.com/d5/tengo/script"
 )
 
@@ -28,3 +29,93 @@ type Extension interface {
 	// Hook will be called on each http request.
 	Hook(sc *script.Compiled, w io.Writer, resp http.ResponseWriter, r *http.Request) error
 }
+
+// RequestContext contains the state of the request a
+// extension function is called in.
+type RequestContext struct {
+	// Request is the current http request.
+	Request *http.Request
+
+	// Response is the response writer of the request. Headers
+	// can be set, but the body should be written to Writer.
+	Response http.ResponseWriter
+
+	// Writer is the document the scripts write to.
+	Writer io.Writer
+
+	// Locals is the http.LOCALS map of the request.
+	Locals *objects.Map
+}
+
+// DebugRecord adds a entry to the debug toolbar of the request like
+// why.DebugRecord. It's safe to call on a nil context, so functions
+// that are also used as module functions can record entries.
+func (ctx *RequestContext) DebugRecord(category string, message string) {
+	if ctx == nil {
+		return
+	}
+	DebugRecord(ctx.Request, category, message)
+}
+
+// ContextFunc is a function of a extension that receives the
+// context of the request it's called in. The context is nil if the
+// function is called as module function (see StaticFuncs).
+type ContextFunc func(ctx *RequestContext, interop objects.Interop, args ...objects.Object) (objects.Object, error)
+
+// RegisterExtension can be implemented by extensions that don't want
+// to rebuild their functions on every request. Register will be called
+// one time when the extension is added to the server. The returned
+// functions will be available to the scripts in a global variable with
+// the returned name and receive the context of the current request.
+// The variable doesn't need to be returned by Vars and Hook can be a
+// no-op.
+type RegisterExtension interface {
+	Register() (string, map[string]ContextFunc)
+}
+
+// ModuleExtension can be implemented by extensions that can be imported
+// by scripts as module (e.g. db := import("bbolt")) instead of only being
+// available as global variable. Module returns the name of the module and
+// its attributes. Because modules are compiled into the scripts and
+// shared by all requests, their functions don't receive a request
+// context.
+type ModuleExtension interface {
+	Module() (string, map[string]objects.Object)
+}
+
+// StaticFuncs converts context functions to module attributes. The
+// functions receive a nil request context, so they must check the
+// context before using it. This can be used to implement
+// ModuleExtension with the functions returned by Register.
+func StaticFuncs(funcs map[string]ContextFunc) map[string]objects.Object {
+	return registeredFuncs{funcs: funcs}.bind(nil).Value
+}
+
+// PostRequestExtension can be implemented by extensions that need
+// to clean up after a request. PostRequest will be called after the
+// response was written with the error of the request, if any.
+type PostRequestExtension interface {
+	PostRequest(ctx *RequestContext, err error)
+}
+
+// registeredFuncs contains the functions of a RegisterExtension.
+type registeredFuncs struct {
+	name  string
+	funcs map[string]ContextFunc
+}
+
+// bind creates the script object of the functions for the given
+// request context.
+func (rf registeredFuncs) bind(ctx *RequestContext) *objects.ImmutableMap {
+	m := &objects.ImmutableMap{Value: make(map[string]objects.Object, len(rf.funcs))}
+	for name, fn := range rf.funcs {
+		fn := fn
+		m.Value[name] = &objects.UserFunction{
+			Name: name,
+			Value: func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+				return fn(ctx, interop, args...)
+			},
+		}
+	}
+	return m
+}
@@ -0,0 +1,120 @@
+package why
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/d5/tengo/objects"
+	"github.com/d5/tengo/script"
+)
+
+func TestStaticFuncs(t *testing.T) {
+	var got *RequestContext
+	called := false
+
+	attrs := StaticFuncs(map[string]ContextFunc{
+		"raw": func(ctx *RequestContext, interop objects.Interop, args ...objects.Object) (objects.Object, error) {
+			got, called = ctx, true
+			ctx.DebugRecord("test", "raw")
+			return nil, nil
+		},
+		"bound": MustBind(func(ctx *RequestContext, value string) string {
+			ctx.DebugRecord("test", value)
+			return value
+		}),
+	})
+
+	if _, err := attrs["raw"].(*objects.UserFunction).Value(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !called || got != nil {
+		t.Fatalf("expected the function to be called with a nil context, got %v", got)
+	}
+
+	ret, err := attrs["bound"].(*objects.UserFunction).Value(nil, &objects.String{Value: "ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := objects.ToString(ret); s != "ok" {
+		t.Fatalf("expected %q, got %v", "ok", ret)
+	}
+}
+
+func TestRegisteredFuncsContext(t *testing.T) {
+	ctx := &RequestContext{Request: httptest.NewRequest(http.MethodGet, "/", nil)}
+
+	var got *RequestContext
+	attrs := registeredFuncs{funcs: map[string]ContextFunc{
+		"fn": func(ctx *RequestContext, interop objects.Interop, args ...objects.Object) (objects.Object, error) {
+			got = ctx
+			return nil, nil
+		},
+	}}.bind(ctx)
+
+	if _, err := attrs.Value["fn"].(*objects.UserFunction).Value(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got != ctx {
+		t.Fatal("expected the function to receive the request context")
+	}
+}
+
+// registeringExtension registers a function and records the bound
+// functions each script received.
+type registeringExtension struct {
+	bound []objects.Object
+}
+
+func (e *registeringExtension) Name() string    { return "registering" }
+func (e *registeringExtension) Init() error     { return nil }
+func (e *registeringExtension) Shutdown() error { return nil }
+func (e *registeringExtension) Vars() []string  { return nil }
+func (e *registeringExtension) Register() (string, map[string]ContextFunc) {
+	return "reg", map[string]ContextFunc{
+		"path": func(ctx *RequestContext, interop objects.Interop, args ...objects.Object) (objects.Object, error) {
+			return &objects.String{Value: ctx.Request.URL.Path}, nil
+		},
+	}
+}
+
+func (e *registeringExtension) Hook(sc *script.Compiled, w io.Writer, resp http.ResponseWriter, r *http.Request) error {
+	e.bound = append(e.bound, sc.Get("reg").Object())
+	return nil
+}
+
+func TestRegisteredFuncsBoundPerRequest(t *testing.T) {
+	dir, cleanup := publicDir(t, map[string]string{
+		"_middleware.tengo": `<!? http.write(reg.path(), ",") ?!>`,
+		"index.tengo":       `<!? http.write(reg.path()) ?!>`,
+	})
+	defer cleanup()
+
+	ext := &registeringExtension{}
+	s := New(&Config{PublicDir: dir})
+	if err := s.AddExtension(ext); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/index", "/index"} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if body := rec.Body.String(); body != "/index,/index" {
+			t.Fatalf("expected the functions to receive the request, got %q", body)
+		}
+	}
+
+	if len(ext.bound) != 4 {
+		t.Fatalf("expected 4 script runs, got %d", len(ext.bound))
+	}
+
+	// The middleware and the page share the functions of a request,
+	// but every request binds its own.
+	if ext.bound[0] != ext.bound[1] || ext.bound[2] != ext.bound[3] {
+		t.Fatal("expected the functions to be bound once per request")
+	}
+	if ext.bound[0] == ext.bound[2] {
+		t.Fatal("expected each request to bind its own functions")
+	}
+}
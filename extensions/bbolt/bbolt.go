@@ -24,6 +24,7 @@ package bbolt
 import (
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/BigJk/why"
 
@@ -35,6 +36,51 @@ import (
 	"go.etcd.io/bbolt"
 )
 
+func init() {
+	why.RegisterExtensionFactory(why.ExtensionFactory{
+		Type:        "bbolt",
+		Description: "Key-value storage",
+		Config: func() interface{} {
+			return &Config{
+				Timeout: why.Duration(time.Second),
+			}
+		},
+		Create: func(conf interface{}) (why.Extension, error) {
+			c := conf.(*Config)
+			return New(c.File, &bbolt.Options{
+				Timeout:         time.Duration(c.Timeout),
+				ReadOnly:        c.ReadOnly,
+				NoSync:          c.NoSync,
+				NoGrowSync:      c.NoGrowSync,
+				InitialMmapSize: c.InitialMmapSize,
+			})
+		},
+		Declare: func(conf interface{}) (why.Extension, error) {
+			// The database isn't opened, so checking scripts
+			// doesn't wait for the lock of a running server.
+			return &Extension{}, nil
+		},
+	})
+}
+
+// Config is the configuration of the bbolt extension.
+type Config struct {
+	File            string       `required:"true" help:"path of the database file"`
+	Timeout         why.Duration `help:"time to wait for the file lock"`
+	ReadOnly        bool         `help:"open the database read-only"`
+	NoSync          bool         `help:"skip fsync after each commit (unsafe)"`
+	NoGrowSync      bool         `help:"skip fsync when growing the file"`
+	InitialMmapSize int          `help:"initial mmap size of the database in bytes"`
+}
+
+// Validate checks the config values.
+func (c *Config) Validate() error {
+	if c.InitialMmapSize < 0 {
+		return &why.FieldError{Field: "InitialMmapSize", Message: "can't be negative"}
+	}
+	return nil
+}
+
 // Extension represents the bbolt extension
 // for the why server.
 type Extension struct {
@@ -72,129 +118,138 @@ func (e *Extension) Init() error {
 
 // Shutdown closes the bbolt extension.
 func (e *Extension) Shutdown() error {
+	if e.conn == nil {
+		return nil
+	}
 	return e.conn.Close()
 }
 
-// Vars returns all the variables bbolt will globally create
+// HealthCheck checks if the database is still open.
+func (e *Extension) HealthCheck() error {
+	return e.conn.View(func(tx *bbolt.Tx) error {
+		return nil
+	})
+}
+
+// Vars returns no variables, because the functions of bbolt
+// are added through Register.
 func (e *Extension) Vars() []string {
-	return []string{"bbolt"}
+	return nil
 }
 
-// Hook will add a variable called 'bbolt' to the
-// script runtime that contains functions to access
-// bbolt.
+// Hook does nothing, because the functions of bbolt are
+// added through Register.
 func (e *Extension) Hook(sc *script.Compiled, w io.Writer, resp http.ResponseWriter, r *http.Request) error {
-	return sc.Set("bbolt", &objects.ImmutableMap{
-		Value: map[string]objects.Object{
-			"set": &objects.UserFunction{
-				Value: func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
-					if len(args) != 3 {
-						return nil, objects.ErrWrongNumArguments
-					}
-
-					bucket, ok := objects.ToString(args[0])
-					if !ok {
-						return nil, errors.New("first argument is not a string")
-					}
-
-					key, ok := objects.ToString(args[1])
-					if !ok {
-						return nil, errors.New("second argument is not a string")
-					}
-
-					data, err := json.Encode(args[2])
-					if err != nil {
-						return nil, err
-					}
-
-					return nil, e.conn.Update(func(tx *bbolt.Tx) error {
-						b, err := tx.CreateBucketIfNotExists([]byte(bucket))
-						if err != nil {
-							return err
-						}
-						return b.Put([]byte(key), data)
-					})
-				},
-			},
-			"get": &objects.UserFunction{
-				Value: func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
-					if len(args) != 2 {
-						return nil, objects.ErrWrongNumArguments
-					}
-
-					bucket, ok := objects.ToString(args[0])
-					if !ok {
-						return nil, errors.New("first argument is not a string")
-					}
-
-					key, ok := objects.ToString(args[1])
-					if !ok {
-						return nil, errors.New("second argument is not a string")
-					}
-
-					var data []byte
-					if err := e.conn.View(func(tx *bbolt.Tx) error {
-						b := tx.Bucket([]byte(bucket))
-						if b == nil {
-							return errors.New("bucket not found")
-						}
-
-						data = b.Get([]byte(key))
-						return nil
-					}); err != nil {
-						return why.ToError(err), nil
-					}
-
-					if len(data) == 0 {
-						return why.ToError(errors.New("not found")), nil
-					}
-					return json.Decode(data)
-				},
-			},
-			"iterate": &objects.UserFunction{
-				Value: func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
-					if len(args) != 2 {
-						return nil, objects.ErrWrongNumArguments
-					}
-
-					bucket, ok := objects.ToString(args[0])
-					if !ok {
-						return nil, errors.New("first argument is not a string")
-					}
-
-					return why.ToError(e.conn.View(func(tx *bbolt.Tx) error {
-						b := tx.Bucket([]byte(bucket))
-						if b == nil {
-							return errors.New("bucket not found")
-						}
-
-						c := b.Cursor()
-
-						for k, v := c.First(); k != nil; k, v = c.Next() {
-							key := &objects.String{
-								Value: string(k),
-							}
-
-							obj, err := json.Decode(v)
-							if err != nil {
-								return err
-							}
-
-							ret, err := interop.InteropCall(args[1], key, obj)
-							if err != nil {
-								return err
-							}
-
-							keepRunning, ok := objects.ToBool(ret)
-							if ok && !keepRunning {
-								break
-							}
-						}
-
-						return nil
-					})), nil
-				},
-			},
-		},
+	return nil
+}
+
+// Register returns the functions that will be available
+// in the 'bbolt' variable of the scripts.
+func (e *Extension) Register() (string, map[string]why.ContextFunc) {
+	return "bbolt", e.funcs()
+}
+
+// Module returns the functions as module, so they can be
+// imported with import("bbolt").
+func (e *Extension) Module() (string, map[string]objects.Object) {
+	return "bbolt", why.StaticFuncs(e.funcs())
+}
+
+func (e *Extension) funcs() map[string]why.ContextFunc {
+	return map[string]why.ContextFunc{
+		"set":     e.set,
+		"get":     why.MustBind(e.get),
+		"iterate": why.MustBind(e.iterate),
+	}
+}
+
+// set isn't bound like the other functions, because its errors are
+// runtime errors instead of error objects.
+func (e *Extension) set(ctx *why.RequestContext, interop objects.Interop, args ...objects.Object) (objects.Object, error) {
+	if len(args) != 3 {
+		return nil, objects.ErrWrongNumArguments
+	}
+
+	bucket, ok := objects.ToString(args[0])
+	if !ok {
+		return nil, errors.New("first argument is not a string")
+	}
+
+	key, ok := objects.ToString(args[1])
+	if !ok {
+		return nil, errors.New("second argument is not a string")
+	}
+
+	data, err := json.Encode(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.DebugRecord("bbolt", "set "+bucket+" / "+key)
+
+	return nil, e.conn.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+func (e *Extension) get(ctx *why.RequestContext, bucket string, key string) (objects.Object, error) {
+	ctx.DebugRecord("bbolt", "get "+bucket+" / "+key)
+
+	var data []byte
+	if err := e.conn.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+
+		data = b.Get([]byte(key))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("not found")
+	}
+	return json.Decode(data)
+}
+
+func (e *Extension) iterate(ctx *why.RequestContext, interop objects.Interop, bucket string, fn objects.Object) error {
+	ctx.DebugRecord("bbolt", "iterate "+bucket)
+
+	return e.conn.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			key := &objects.String{
+				Value: string(k),
+			}
+
+			obj, err := json.Decode(v)
+			if err != nil {
+				return err
+			}
+
+			ret, err := interop.InteropCall(fn, key, obj)
+			if err != nil {
+				return err
+			}
+
+			keepRunning, ok := objects.ToBool(ret)
+			if ok && !keepRunning {
+				break
+			}
+		}
+
+		return nil
 	})
 }
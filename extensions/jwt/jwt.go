@@ -23,7 +23,9 @@ package jwt
 import (
 	"encoding/base64"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/BigJk/why"
 
@@ -36,6 +38,63 @@ import (
 	"github.com/dgrijalva/jwt-go"
 )
 
+func init() {
+	why.RegisterExtensionFactory(why.ExtensionFactory{
+		Type:        "jwt",
+		Description: "Generate JWT's and validate and extract data from them",
+		Config: func() interface{} {
+			return &Config{}
+		},
+		Create: func(conf interface{}) (why.Extension, error) {
+			c := conf.(*Config)
+			if len(c.SecretFile) > 0 {
+				secret, err := readSecret(c.SecretFile)
+				if err != nil {
+					return nil, err
+				}
+				return New(secret)
+			}
+			return New(c.Secret)
+		},
+	})
+}
+
+// Config is the configuration of the jwt extension. Either
+// Secret or SecretFile needs to be set.
+type Config struct {
+	Secret     string `secret:"true" help:"secret the tokens are signed with"`
+	SecretFile string `help:"file containing the secret (e.g. a docker secret)"`
+}
+
+// Validate checks that exactly one secret source is set.
+func (c *Config) Validate() error {
+	switch {
+	case len(c.Secret) > 0 && len(c.SecretFile) > 0:
+		return &why.FieldError{Field: "SecretFile", Message: "can't be used together with Secret"}
+	case len(c.SecretFile) > 0:
+		secret, err := readSecret(c.SecretFile)
+		if err != nil {
+			return &why.FieldError{Field: "SecretFile", Message: err.Error()}
+		}
+		if len(secret) == 0 {
+			return &why.FieldError{Field: "SecretFile", Message: "file is empty"}
+		}
+	case len(c.Secret) == 0:
+		return &why.FieldError{Field: "Secret", Message: "is required if no SecretFile is set"}
+	}
+	return nil
+}
+
+// readSecret reads a secret from a file. Surrounding whitespace
+// (e.g. a trailing newline) is removed.
+func readSecret(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
 // Extension represents the jwt extension
 // for the why server.
 type Extension struct {
@@ -65,83 +124,98 @@ func (e *Extension) Shutdown() error {
 	return nil
 }
 
-// Vars returns all the variables jwt will globally create
+// Vars returns no variables, because the functions of jwt
+// are added through Register.
 func (e *Extension) Vars() []string {
-	return []string{"jwt"}
+	return nil
 }
 
-// Hook will add a variable called 'jwt' to the
-// script runtime that contains functions to generate
-// jwt tokens and extract the token data.
+// Hook does nothing, because the functions of jwt are
+// added through Register.
 func (e *Extension) Hook(sc *script.Compiled, w io.Writer, resp http.ResponseWriter, r *http.Request) error {
-	return sc.Set("jwt", &objects.ImmutableMap{
-		Value: map[string]objects.Object{
-			"generate": &objects.UserFunction{
-				Value: func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
-					if len(args) != 1 {
-						return nil, objects.ErrWrongNumArguments
-					}
-
-					data, err := json.Encode(args[0])
-					if err != nil {
-						return nil, err
-					}
-
-					token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
-						"data": data,
-					})
-
-					tokenString, err := token.SignedString([]byte(e.secret))
-					if err != nil {
-						return nil, err
-					}
-
-					return &objects.String{
-						Value: tokenString,
-					}, nil
-				},
-			},
-			"extract": &objects.UserFunction{
-				Value: func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
-					if len(args) != 1 {
-						return nil, objects.ErrWrongNumArguments
-					}
-
-					tokenString, ok := objects.ToString(args[0])
-					if !ok {
-						return nil, errors.New("first argument was not a string")
-					}
-
-					token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-						if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-							return nil, errors.Errorf("Unexpected signing method: %v", token.Header["alg"])
-						}
-
-						return []byte(e.secret), nil
-					})
-
-					if err != nil {
-						return &objects.Error{
-							Value: &objects.String{
-								Value: err.Error(),
-							},
-						}, nil
-					}
-
-					if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-						if data, ok := claims["data"]; ok {
-							if base, ok := data.(string); ok {
-								decoded, err := base64.StdEncoding.DecodeString(base)
-								if err == nil {
-									return json.Decode(decoded)
-								}
-							}
-						}
-					}
-
-					return why.ToError(errors.New("invalid token")), nil
-				},
-			},
-		},
+	return nil
+}
+
+// Register returns the functions that will be available in
+// the 'jwt' variable of the scripts to generate jwt tokens
+// and extract the token data.
+func (e *Extension) Register() (string, map[string]why.ContextFunc) {
+	return "jwt", e.funcs()
+}
+
+// Module returns the functions as module, so they can be
+// imported with import("jwt").
+func (e *Extension) Module() (string, map[string]objects.Object) {
+	return "jwt", why.StaticFuncs(e.funcs())
+}
+
+func (e *Extension) funcs() map[string]why.ContextFunc {
+	return map[string]why.ContextFunc{
+		"generate": e.generate,
+		"extract":  e.extract,
+	}
+}
+
+func (e *Extension) generate(ctx *why.RequestContext, interop objects.Interop, args ...objects.Object) (objects.Object, error) {
+	if len(args) != 1 {
+		return nil, objects.ErrWrongNumArguments
+	}
+
+	data, err := json.Encode(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"data": data,
 	})
+
+	tokenString, err := token.SignedString([]byte(e.secret))
+	if err != nil {
+		return nil, err
+	}
+
+	return &objects.String{
+		Value: tokenString,
+	}, nil
+}
+
+func (e *Extension) extract(ctx *why.RequestContext, interop objects.Interop, args ...objects.Object) (objects.Object, error) {
+	if len(args) != 1 {
+		return nil, objects.ErrWrongNumArguments
+	}
+
+	tokenString, ok := objects.ToString(args[0])
+	if !ok {
+		return nil, errors.New("first argument was not a string")
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return []byte(e.secret), nil
+	})
+
+	if err != nil {
+		return &objects.Error{
+			Value: &objects.String{
+				Value: err.Error(),
+			},
+		}, nil
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		if data, ok := claims["data"]; ok {
+			if base, ok := data.(string); ok {
+				decoded, err := base64.StdEncoding.DecodeString(base)
+				if err == nil {
+					return json.Decode(decoded)
+				}
+			}
+		}
+	}
+
+	return why.ToError(errors.New("invalid token")), nil
 }
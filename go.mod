@@ -3,6 +3,7 @@ module github.com/BigJk/why
 go 1.12
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/cespare/xxhash v1.1.0
 	github.com/d5/tengo v1.24.2-0.20190613025834-dfc79c2eb775
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
@@ -12,4 +13,5 @@ require (
 	go.etcd.io/bbolt v1.3.3
 	go.uber.org/atomic v1.4.0
 	golang.org/x/sys v0.0.0-20190620070143-6f217b454f45 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
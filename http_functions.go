@@ -2,24 +2,35 @@ package why
 
 import (
 	"bytes"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"html"
 	"io"
-	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/d5/tengo/objects"
 	"github.com/d5/tengo/script"
+	"github.com/d5/tengo/stdlib/json"
 )
 
 type scriptInstance struct {
 	script     *script.Compiled
 	buf        *bytes.Buffer
 	req        *http.Request
+	body       []byte
 	statusCode *int
 	respWriter http.ResponseWriter
+	basePath   string
+	locals     *objects.Map
+	ctx        *RequestContext
+	registered []*objects.ImmutableMap
+	aborted    bool
 	cut        int
 }
 
@@ -35,8 +46,53 @@ func valuesToObject(v url.Values) (*objects.Array, error) {
 	return &objects.Array{Value: keys}, nil
 }
 
-func stopRequest() objects.CallableFunc {
+func stringsToObject(values []string) *objects.ImmutableArray {
+	arr := &objects.ImmutableArray{Value: make([]objects.Object, len(values))}
+	for i := range values {
+		arr.Value[i] = &objects.String{Value: values[i]}
+	}
+	return arr
+}
+
+func valuesToMap(v map[string][]string) *objects.ImmutableMap {
+	m := &objects.ImmutableMap{Value: make(map[string]objects.Object, len(v))}
+	for key := range v {
+		m.Value[key] = stringsToObject(v[key])
+	}
+	return m
+}
+
+func getAllValues(values func() map[string][]string, canonicalKey func(string) string) objects.CallableFunc {
+	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		if len(args) != 1 {
+			return nil, objects.ErrWrongNumArguments
+		}
+
+		key, ok := objects.ToString(args[0])
+		if !ok {
+			return nil, errors.New("not a string")
+		}
+
+		if canonicalKey != nil {
+			key = canonicalKey(key)
+		}
+
+		return stringsToObject(values()[key]), nil
+	}
+}
+
+func getValueMap(values func() map[string][]string) objects.CallableFunc {
+	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		if len(args) != 0 {
+			return nil, objects.ErrWrongNumArguments
+		}
+		return valuesToMap(values()), nil
+	}
+}
+
+func stopRequest(si *scriptInstance) objects.CallableFunc {
 	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		si.aborted = true
 		return nil, requestedAbort
 	}
 }
@@ -87,6 +143,218 @@ func setStatusCode(code *int) objects.CallableFunc {
 	}
 }
 
+// optionalStatusCode sets the status code to the optional int argument
+// at position i. If the argument isn't present a status code that was
+// set before (e.g. with http.status_code) is kept and def is only used
+// if the status code is still the initial 200.
+func optionalStatusCode(code *int, def int, args []objects.Object, i int) error {
+	if len(args) <= i {
+		if *code == http.StatusOK {
+			*code = def
+		}
+		return nil
+	}
+
+	newCode, ok := objects.ToInt(args[i])
+	if !ok {
+		return errors.New("status code wasn't a int")
+	}
+
+	*code = newCode
+	return nil
+}
+
+func writeJSONResponse(si *scriptInstance) objects.CallableFunc {
+	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, objects.ErrWrongNumArguments
+		}
+
+		data, err := json.Encode(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if err := optionalStatusCode(si.statusCode, http.StatusOK, args, 1); err != nil {
+			return nil, err
+		}
+
+		si.respWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+		si.buf.Reset()
+		_, err = si.buf.Write(data)
+		return nil, err
+	}
+}
+
+func writeText(si *scriptInstance) objects.CallableFunc {
+	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, objects.ErrWrongNumArguments
+		}
+
+		if err := optionalStatusCode(si.statusCode, http.StatusOK, args, 1); err != nil {
+			return nil, err
+		}
+
+		si.respWriter.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		return overwriteHTML(si.buf)(interop, args[0])
+	}
+}
+
+func redirect(si *scriptInstance) objects.CallableFunc {
+	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, objects.ErrWrongNumArguments
+		}
+
+		location, ok := objects.ToString(args[0])
+		if !ok {
+			return nil, errors.New("url wasn't a string")
+		}
+
+		// A status code that was set before is only kept if it is a
+		// redirect, because browsers don't follow the Location header
+		// of other responses.
+		if *si.statusCode < 300 || *si.statusCode > 399 {
+			*si.statusCode = http.StatusFound
+		}
+
+		if err := optionalStatusCode(si.statusCode, http.StatusFound, args, 1); err != nil {
+			return nil, err
+		}
+
+		si.respWriter.Header().Set("Location", location)
+		si.buf.Reset()
+		return nil, nil
+	}
+}
+
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+// parseAccept parses the media ranges of a Accept header.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+
+		ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality})
+	}
+	return ranges
+}
+
+// acceptQuality returns the quality of the most specific media range
+// that matches the offered media type.
+func acceptQuality(ranges []acceptRange, offer string) float64 {
+	quality, specificity := 0.0, -1
+	for i := range ranges {
+		var spec int
+		switch {
+		case ranges[i].mediaType == offer:
+			spec = 2
+		case strings.HasSuffix(ranges[i].mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(ranges[i].mediaType, "*")):
+			spec = 1
+		case ranges[i].mediaType == "*/*":
+			spec = 0
+		default:
+			continue
+		}
+
+		if spec > specificity {
+			quality, specificity = ranges[i].quality, spec
+		}
+	}
+	return quality
+}
+
+func negotiate(r *http.Request) objects.CallableFunc {
+	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		if len(args) == 0 {
+			return nil, objects.ErrWrongNumArguments
+		}
+
+		var offers []string
+		for i := range args {
+			offer, ok := objects.ToString(args[i])
+			if !ok {
+				return nil, errors.New("offer wasn't a string")
+			}
+			offers = append(offers, offer)
+		}
+
+		// Without a Accept header every representation is acceptable.
+		header := r.Header.Get("Accept")
+		if len(header) == 0 {
+			return &objects.String{Value: offers[0]}, nil
+		}
+
+		ranges := parseAccept(header)
+		best, bestQuality := "", 0.0
+		for i := range offers {
+			if q := acceptQuality(ranges, offers[i]); q > bestQuality {
+				best, bestQuality = offers[i], q
+			}
+		}
+
+		if bestQuality == 0 {
+			return objects.UndefinedValue, nil
+		}
+
+		return &objects.String{Value: best}, nil
+	}
+}
+
+// urlFor builds a url relative to the base path of the server. The
+// optional second argument is a map of query parameters.
+func urlFor(basePath string) objects.CallableFunc {
+	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, objects.ErrWrongNumArguments
+		}
+
+		target, ok := objects.ToString(args[0])
+		if !ok {
+			return nil, errors.New("path wasn't a string")
+		}
+
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+
+		if !u.IsAbs() {
+			u.Path = basePath + "/" + strings.TrimLeft(u.Path, "/")
+		}
+
+		if len(args) == 2 {
+			params, ok := objects.ToInterface(args[1]).(map[string]interface{})
+			if !ok {
+				return nil, errors.New("params wasn't a map")
+			}
+
+			query := u.Query()
+			for key, value := range params {
+				query.Set(key, fmt.Sprint(value))
+			}
+			u.RawQuery = query.Encode()
+		}
+
+		return &objects.String{Value: u.String()}, nil
+	}
+}
+
 func escapeHTML(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
 	if len(args) == 0 {
 		return nil, objects.ErrWrongNumArguments
@@ -209,16 +477,119 @@ func setHeader(w http.ResponseWriter) objects.CallableFunc {
 	}
 }
 
-func getBody(r *http.Request) objects.CallableFunc {
+func getBody(si *scriptInstance) objects.CallableFunc {
+	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		if len(args) != 0 {
+			return nil, objects.ErrWrongNumArguments
+		}
+		return &objects.Bytes{Value: si.body}, nil
+	}
+}
+
+// hasContentType checks if the media type of the request matches
+// one of the given types or ends with the given structured syntax
+// suffix (e.g. "+json").
+func hasContentType(r *http.Request, suffix string, types ...string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	for i := range types {
+		if mediaType == types[i] {
+			return true
+		}
+	}
+
+	return strings.HasSuffix(mediaType, suffix)
+}
+
+func getJSON(si *scriptInstance) objects.CallableFunc {
 	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
 		if len(args) != 0 {
 			return nil, objects.ErrWrongNumArguments
 		}
-		data, err := ioutil.ReadAll(r.Body)
+
+		if !hasContentType(si.req, "+json", "application/json") {
+			return ToError(errors.New("content type is not json")), nil
+		}
+
+		if len(si.body) == 0 {
+			return ToError(errors.New("empty body")), nil
+		}
+
+		obj, err := json.Decode(si.body)
+		if err != nil {
+			return ToError(err), nil
+		}
+
+		return obj, nil
+	}
+}
+
+// xmlToObject decodes the next element of the decoder into a map
+// containing the name, attributes, text and child elements.
+func xmlToObject(dec *xml.Decoder, start xml.StartElement) (objects.Object, error) {
+	attrs := map[string]objects.Object{}
+	for i := range start.Attr {
+		attrs[start.Attr[i].Name.Local] = &objects.String{Value: start.Attr[i].Value}
+	}
+
+	var text strings.Builder
+	var children []objects.Object
+	for {
+		tok, err := dec.Token()
 		if err != nil {
 			return nil, err
 		}
-		return &objects.Bytes{Value: data}, nil
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := xmlToObject(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, child)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			return &objects.ImmutableMap{
+				Value: map[string]objects.Object{
+					"name":     &objects.String{Value: start.Name.Local},
+					"attrs":    &objects.ImmutableMap{Value: attrs},
+					"text":     &objects.String{Value: strings.TrimSpace(text.String())},
+					"children": &objects.ImmutableArray{Value: children},
+				},
+			}, nil
+		}
+	}
+}
+
+func getXML(si *scriptInstance) objects.CallableFunc {
+	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		if len(args) != 0 {
+			return nil, objects.ErrWrongNumArguments
+		}
+
+		if !hasContentType(si.req, "+xml", "application/xml", "text/xml") {
+			return ToError(errors.New("content type is not xml")), nil
+		}
+
+		dec := xml.NewDecoder(bytes.NewReader(si.body))
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return ToError(err), nil
+			}
+
+			if start, ok := tok.(xml.StartElement); ok {
+				obj, err := xmlToObject(dec, start)
+				if err != nil {
+					return ToError(err), nil
+				}
+				return obj, nil
+			}
+		}
 	}
 }
 
@@ -312,18 +683,38 @@ func setCookie(resp http.ResponseWriter) objects.CallableFunc {
 	}
 }
 
-func addHTTP(si *scriptInstance) error {
-	return si.script.Set("http", &objects.ImmutableMap{
+func addHTTP(si *scriptInstance, log *objects.ImmutableMap) error {
+	obj := httpObject(si)
+	obj.Value["log"] = log
+	return si.script.Set("http", obj)
+}
+
+// httpObject creates the http variable of the script instance.
+func httpObject(si *scriptInstance) *objects.ImmutableMap {
+	postForm := func() map[string][]string { return si.req.PostForm }
+	query := func() map[string][]string { return si.req.URL.Query() }
+	header := func() map[string][]string { return si.req.Header }
+
+	return &objects.ImmutableMap{
 		Value: map[string]objects.Object{
 			"method": &objects.String{
 				Value: si.req.Method,
 			},
+			"request_id": &objects.String{
+				Value: getRequestInfo(si.req).id,
+			},
 			"full_uri": &objects.String{
 				Value: si.req.RequestURI,
 			},
 			"path": &objects.String{
 				Value: si.req.URL.Path,
 			},
+			"base_path": &objects.String{
+				Value: si.basePath,
+			},
+			"url_for": &objects.UserFunction{
+				Value: urlFor(si.basePath),
+			},
 			"scheme": &objects.String{
 				Value: si.req.URL.Scheme,
 			},
@@ -346,14 +737,33 @@ func addHTTP(si *scriptInstance) error {
 				Value: setStatusCode(si.statusCode),
 			},
 			"die": &objects.UserFunction{
-				Value: stopRequest(),
+				Value: stopRequest(si),
+			},
+			"json_response": &objects.UserFunction{
+				Value: writeJSONResponse(si),
+			},
+			"text": &objects.UserFunction{
+				Value: writeText(si),
+			},
+			"redirect": &objects.UserFunction{
+				Value: redirect(si),
+			},
+			"negotiate": &objects.UserFunction{
+				Value: negotiate(si.req),
 			},
 			"escape": &objects.UserFunction{
 				Value: escapeHTML,
 			},
 			"body": &objects.UserFunction{
-				Value: getBody(si.req),
+				Value: getBody(si),
+			},
+			"json": &objects.UserFunction{
+				Value: getJSON(si),
 			},
+			"xml": &objects.UserFunction{
+				Value: getXML(si),
+			},
+			"LOCALS": si.locals,
 			"GET": &objects.ImmutableMap{
 				Value: map[string]objects.Object{
 					"keys": &objects.UserFunction{
@@ -362,6 +772,12 @@ func addHTTP(si *scriptInstance) error {
 					"param": &objects.UserFunction{
 						Value: getGetParam(si.req),
 					},
+					"all": &objects.UserFunction{
+						Value: getAllValues(query, nil),
+					},
+					"map": &objects.UserFunction{
+						Value: getValueMap(query),
+					},
 				},
 			},
 			"POST": &objects.ImmutableMap{
@@ -372,6 +788,12 @@ func addHTTP(si *scriptInstance) error {
 					"param": &objects.UserFunction{
 						Value: getPostParam(si.req),
 					},
+					"all": &objects.UserFunction{
+						Value: getAllValues(postForm, nil),
+					},
+					"map": &objects.UserFunction{
+						Value: getValueMap(postForm),
+					},
 				},
 			},
 			"HEADER": &objects.ImmutableMap{
@@ -382,6 +804,12 @@ func addHTTP(si *scriptInstance) error {
 					"param": &objects.UserFunction{
 						Value: getHeader(si.req),
 					},
+					"all": &objects.UserFunction{
+						Value: getAllValues(header, http.CanonicalHeaderKey),
+					},
+					"map": &objects.UserFunction{
+						Value: getValueMap(header),
+					},
 					"set": &objects.UserFunction{
 						Value: setHeader(si.respWriter),
 					},
@@ -401,5 +829,5 @@ func addHTTP(si *scriptInstance) error {
 				},
 			},
 		},
-	})
+	}
 }
@@ -0,0 +1,250 @@
+package why
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/d5/tengo/objects"
+)
+
+// newTestInstance creates a script instance for the request.
+func newTestInstance(r *http.Request) *scriptInstance {
+	statusCode := http.StatusOK
+	return &scriptInstance{
+		buf:        &bytes.Buffer{},
+		req:        r,
+		statusCode: &statusCode,
+		respWriter: httptest.NewRecorder(),
+		locals:     &objects.Map{Value: map[string]objects.Object{}},
+	}
+}
+
+// call calls the function at the path (e.g. "POST.all") of the http object.
+func call(t *testing.T, si *scriptInstance, path string, args ...objects.Object) objects.Object {
+	var obj objects.Object = httpObject(si)
+	for _, name := range strings.Split(path, ".") {
+		obj = obj.(*objects.ImmutableMap).Value[name]
+	}
+
+	ret, err := obj.(*objects.UserFunction).Value(nil, args...)
+	if err != nil {
+		t.Fatalf("%s returned error: %v", path, err)
+	}
+	return ret
+}
+
+func arrayStrings(o objects.Object) []string {
+	var values []string
+	for _, v := range o.(*objects.ImmutableArray).Value {
+		values = append(values, v.(*objects.String).Value)
+	}
+	return values
+}
+
+func TestMultiValueAccessors(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/?tags=query&page=1", strings.NewReader("tags=a&tags=b"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Add("X-Forwarded-For", "1.1.1.1")
+	r.Header.Add("X-Forwarded-For", "2.2.2.2")
+	if err := r.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+
+	si := newTestInstance(r)
+
+	tests := []struct {
+		path string
+		key  string
+		want []string
+	}{
+		{"GET.all", "tags", []string{"query"}},
+		{"POST.all", "tags", []string{"a", "b"}},
+		{"POST.all", "page", nil},
+		{"HEADER.all", "x-forwarded-for", []string{"1.1.1.1", "2.2.2.2"}},
+	}
+
+	for _, test := range tests {
+		got := arrayStrings(call(t, si, test.path, &objects.String{Value: test.key}))
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s(%q) = %v, want %v", test.path, test.key, got, test.want)
+		}
+	}
+
+	// POST.map and POST.all need to agree and not contain query values.
+	post := call(t, si, "POST.map").(*objects.ImmutableMap)
+	if _, ok := post.Value["page"]; ok {
+		t.Error("POST.map contains query parameter")
+	}
+	if got := arrayStrings(post.Value["tags"]); strings.Join(got, ",") != "a,b" {
+		t.Errorf("POST.map tags = %v, want [a b]", got)
+	}
+}
+
+func TestOptionalStatusCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		current int
+		def     int
+		args    []objects.Object
+		want    int
+	}{
+		{name: "default", current: http.StatusOK, def: http.StatusFound, want: http.StatusFound},
+		{name: "keep previous", current: http.StatusNotFound, def: http.StatusOK, want: http.StatusNotFound},
+		{name: "keep previous redirect", current: http.StatusMovedPermanently, def: http.StatusFound, want: http.StatusMovedPermanently},
+		{name: "argument", current: http.StatusNotFound, def: http.StatusOK, args: []objects.Object{nil, &objects.Int{Value: 201}}, want: http.StatusCreated},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code := test.current
+			if err := optionalStatusCode(&code, test.def, test.args, 1); err != nil {
+				t.Fatal(err)
+			}
+			if code != test.want {
+				t.Fatalf("expected %d, got %d", test.want, code)
+			}
+		})
+	}
+}
+
+func TestRedirectStatusCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		current int
+		args    []objects.Object
+		want    int
+	}{
+		{name: "default", current: http.StatusOK, want: http.StatusFound},
+		{name: "keep previous redirect", current: http.StatusMovedPermanently, want: http.StatusMovedPermanently},
+		{name: "replace previous error", current: http.StatusNotFound, want: http.StatusFound},
+		{name: "replace previous success", current: http.StatusCreated, want: http.StatusFound},
+		{name: "argument", current: http.StatusNotFound, args: []objects.Object{&objects.Int{Value: http.StatusSeeOther}}, want: http.StatusSeeOther},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			si := newTestInstance(httptest.NewRequest(http.MethodGet, "/", nil))
+			*si.statusCode = test.current
+
+			call(t, si, "redirect", append([]objects.Object{&objects.String{Value: "/target"}}, test.args...)...)
+
+			if *si.statusCode != test.want {
+				t.Fatalf("expected status %d, got %d", test.want, *si.statusCode)
+			}
+			if location := si.respWriter.Header().Get("Location"); location != "/target" {
+				t.Fatalf("expected location /target, got %q", location)
+			}
+		})
+	}
+}
+
+func TestStatusPreservedByResponseHelpers(t *testing.T) {
+	si := newTestInstance(httptest.NewRequest(http.MethodGet, "/", nil))
+
+	call(t, si, "status_code", &objects.Int{Value: http.StatusNotFound})
+	call(t, si, "json_response", &objects.Map{Value: map[string]objects.Object{}})
+
+	if *si.statusCode != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, *si.statusCode)
+	}
+}
+
+func TestParseAccept(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []acceptRange
+	}{
+		{header: "", want: nil},
+		{header: "text/html", want: []acceptRange{{"text/html", 1}}},
+		{header: "text/html;q=0.5, application/json", want: []acceptRange{{"text/html", 0.5}, {"application/json", 1}}},
+		{header: "text/*;q=0.3, */*;q=0.1", want: []acceptRange{{"text/*", 0.3}, {"*/*", 0.1}}},
+		{header: "text/html;q=abc", want: []acceptRange{{"text/html", 1}}},
+		{header: "invalid/, application/xml", want: []acceptRange{{"application/xml", 1}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			got := parseAccept(test.header)
+			if len(got) != len(test.want) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("expected %v, got %v", test.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		offers []string
+		want   string
+	}{
+		{accept: "", offers: []string{"text/html", "application/json"}, want: "text/html"},
+		{accept: "application/json", offers: []string{"text/html", "application/json"}, want: "application/json"},
+		{accept: "text/html;q=0.5, application/json", offers: []string{"text/html", "application/json"}, want: "application/json"},
+		{accept: "text/*;q=0.9, text/plain;q=0.1", offers: []string{"text/plain", "text/html"}, want: "text/html"},
+		{accept: "*/*", offers: []string{"text/html", "application/json"}, want: "text/html"},
+		{accept: "image/png", offers: []string{"text/html"}, want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if len(test.accept) > 0 {
+				r.Header.Set("Accept", test.accept)
+			}
+
+			var args []objects.Object
+			for _, offer := range test.offers {
+				args = append(args, &objects.String{Value: offer})
+			}
+
+			ret := call(t, newTestInstance(r), "negotiate", args...)
+			got, _ := ret.(*objects.String)
+			if (got == nil && len(test.want) > 0) || (got != nil && got.Value != test.want) {
+				t.Fatalf("expected %q, got %v", test.want, ret)
+			}
+		})
+	}
+}
+
+func TestURLFor(t *testing.T) {
+	tests := []struct {
+		base   string
+		target string
+		params map[string]objects.Object
+		want   string
+	}{
+		{base: "", target: "/page", want: "/page"},
+		{base: "/app", target: "/page", want: "/app/page"},
+		{base: "/app", target: "page", want: "/app/page"},
+		{base: "/app", target: "/", want: "/app/"},
+		{base: "/app", target: "/page#top", want: "/app/page#top"},
+		{base: "/app", target: "https://example.com/page", want: "https://example.com/page"},
+		{base: "/app", target: "/search?q=a", params: map[string]objects.Object{"page": &objects.Int{Value: 2}}, want: "/app/search?page=2&q=a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.base+" "+test.target, func(t *testing.T) {
+			args := []objects.Object{&objects.String{Value: test.target}}
+			if test.params != nil {
+				args = append(args, &objects.Map{Value: test.params})
+			}
+
+			ret, err := urlFor(test.base)(nil, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ret.(*objects.String).Value; got != test.want {
+				t.Fatalf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package why
+
+import (
+	"context"
+	"sync"
+)
+
+// inFlight counts the running script executions so that
+// the server can wait for them to finish on shutdown.
+type inFlight struct {
+	mtx      sync.Mutex
+	count    int
+	draining bool
+	idle     chan struct{}
+}
+
+// tryAdd adds a execution if the server isn't draining. Checking and
+// adding under the same lock guarantees that no execution can start
+// after drain returned.
+func (f *inFlight) tryAdd() bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.draining {
+		return false
+	}
+
+	f.count++
+	return true
+}
+
+// drain stops new executions from being added.
+func (f *inFlight) drain() {
+	f.mtx.Lock()
+	f.draining = true
+	f.mtx.Unlock()
+}
+
+func (f *inFlight) isDraining() bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.draining
+}
+
+func (f *inFlight) done() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.count--
+	if f.count == 0 && f.idle != nil {
+		close(f.idle)
+		f.idle = nil
+	}
+}
+
+func (f *inFlight) len() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.count
+}
+
+// wait blocks until no execution is running anymore
+// or the context is done.
+func (f *inFlight) wait(ctx context.Context) error {
+	f.mtx.Lock()
+	if f.count == 0 {
+		f.mtx.Unlock()
+		return nil
+	}
+
+	if f.idle == nil {
+		f.idle = make(chan struct{})
+	}
+	idle := f.idle
+	f.mtx.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,34 @@
+package why
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInFlightDrain(t *testing.T) {
+	f := &inFlight{}
+
+	if !f.tryAdd() {
+		t.Fatal("expected execution to be added before draining")
+	}
+
+	f.drain()
+	if f.tryAdd() {
+		t.Fatal("expected execution to be rejected while draining")
+	}
+	if f.len() != 1 {
+		t.Fatalf("expected 1 execution, got %d", f.len())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := f.wait(ctx); err == nil {
+		t.Fatal("expected wait to time out while a execution is running")
+	}
+
+	f.done()
+	if err := f.wait(context.Background()); err != nil {
+		t.Fatalf("expected wait to return after the execution is done, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package why
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/d5/tengo/objects"
+	"github.com/pkg/errors"
+)
+
+// LogLevel represents the severity of a log message.
+type LogLevel int
+
+// All the available log levels.
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the name of the level.
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogError {
+		return "unknown"
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel parses the name of a log level. A empty
+// name will be parsed as info level.
+func ParseLogLevel(name string) (LogLevel, error) {
+	if len(name) == 0 {
+		return LogInfo, nil
+	}
+
+	for i := range logLevelNames {
+		if strings.EqualFold(name, logLevelNames[i]) {
+			return LogLevel(i), nil
+		}
+	}
+
+	return LogInfo, errors.Errorf("unknown log level '%s'", name)
+}
+
+// Logger receives the log messages of the scripts. It can be
+// implemented to route the messages to a structured logging
+// system.
+type Logger interface {
+	Log(level LogLevel, msg string, fields map[string]interface{})
+}
+
+type stdLogger struct{}
+
+// Log writes the message and the sorted fields as key=value
+// pairs through the standard log package.
+func (stdLogger) Log(level LogLevel, msg string, fields map[string]interface{}) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var line strings.Builder
+	line.WriteString("[" + strings.ToUpper(level.String()) + "] " + msg)
+	for i := range keys {
+		line.WriteString(fmt.Sprintf(" %s=%q", keys[i], fmt.Sprint(fields[keys[i]])))
+	}
+
+	log.Println(line.String())
+}
+
+// logFunc creates the script function of a log level. The first
+// argument is the message, followed by either a map of fields or
+// key value pairs.
+func logFunc(logger Logger, min LogLevel, level LogLevel, tags map[string]interface{}) objects.CallableFunc {
+	return func(interop objects.Interop, args ...objects.Object) (ret objects.Object, err error) {
+		if len(args) == 0 {
+			return nil, objects.ErrWrongNumArguments
+		}
+
+		if level < min {
+			return nil, nil
+		}
+
+		msg, ok := objects.ToString(args[0])
+		if !ok {
+			msg = args[0].String()
+		}
+
+		fields := make(map[string]interface{}, len(tags)+len(args)/2)
+
+		if len(args) == 2 {
+			if m, ok := objects.ToInterface(args[1]).(map[string]interface{}); ok {
+				for key, value := range m {
+					fields[key] = value
+				}
+			} else {
+				return nil, errors.New("fields need to be a map or key value pairs")
+			}
+		} else if len(args) > 1 {
+			if len(args)%2 == 0 {
+				return nil, errors.New("missing value of key value pair")
+			}
+
+			for i := 1; i < len(args); i += 2 {
+				key, ok := objects.ToString(args[i])
+				if !ok {
+					return nil, errors.New("key wasn't a string")
+				}
+				fields[key] = objects.ToInterface(args[i+1])
+			}
+		}
+
+		for key, value := range tags {
+			fields[key] = value
+		}
+
+		logger.Log(level, msg, fields)
+		return nil, nil
+	}
+}
+
+// logObject creates the http.log object of the script. All messages
+// will be tagged with the request id and the path of the script.
+func (s *Server) logObject(si *scriptInstance, scriptPath string) *objects.ImmutableMap {
+	tags := map[string]interface{}{
+		"request_id": getRequestInfo(si.req).id,
+		"script":     scriptPath,
+	}
+
+	funcs := map[string]objects.Object{}
+	for level := LogDebug; level <= LogError; level++ {
+		funcs[level.String()] = &objects.UserFunction{
+			Value: logFunc(s.logger, s.logLevel, level, tags),
+		}
+	}
+
+	return &objects.ImmutableMap{Value: funcs}
+}
@@ -0,0 +1,54 @@
+package why
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/d5/tengo/objects"
+)
+
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+type recordingLogger struct {
+	entries []logEntry
+}
+
+func (l *recordingLogger) Log(level LogLevel, msg string, fields map[string]interface{}) {
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func TestLogObject(t *testing.T) {
+	logger := &recordingLogger{}
+	s := &Server{logger: logger, logLevel: LogInfo}
+	si := newTestInstance(httptest.NewRequest(http.MethodGet, "/", nil))
+
+	log := s.logObject(si, "/index.tengo")
+	for _, c := range []struct {
+		level string
+		args  []objects.Object
+	}{
+		{level: "debug", args: []objects.Object{&objects.String{Value: "dropped"}}},
+		{level: "info", args: []objects.Object{&objects.String{Value: "login"}, &objects.String{Value: "user"}, &objects.String{Value: "alice"}}},
+	} {
+		if _, err := log.Value[c.level].(*objects.UserFunction).Value(nil, c.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(logger.entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(logger.entries))
+	}
+
+	entry := logger.entries[0]
+	if entry.level != LogInfo || entry.msg != "login" {
+		t.Fatalf("unexpected entry %v", entry)
+	}
+	if entry.fields["user"] != "alice" || entry.fields["script"] != "/index.tengo" {
+		t.Fatalf("unexpected fields %v", entry.fields)
+	}
+}
@@ -0,0 +1,315 @@
+package why
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// Metric types of the Prometheus text format.
+const (
+	MetricCounter   = "counter"
+	MetricGauge     = "gauge"
+	MetricHistogram = "histogram"
+)
+
+// Metric is a single sample that will be exposed on the
+// metrics endpoint.
+type Metric struct {
+	Name string
+
+	// Family is the name of the metric the sample belongs to. It
+	// defaults to Name and only needs to be set if the sample name
+	// differs (e.g. the _bucket, _sum and _count samples of a
+	// histogram), so that HELP and TYPE are written for the family.
+	Family string
+
+	Help   string
+	Type   string
+	Labels map[string]string
+	Value  float64
+}
+
+// MetricsExtension can be implemented by extensions that want to
+// expose their own metrics on the metrics endpoint.
+type MetricsExtension interface {
+	Metrics() []Metric
+}
+
+// Script error types counted in the metrics.
+const (
+	errorTranspile = "transpile"
+	errorCompile   = "compile"
+	errorExtension = "extension"
+	errorRuntime   = "runtime"
+)
+
+// durationBuckets are the upper bounds in seconds of the
+// request duration histogram.
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *histogram) observe(value float64) {
+	for i := range durationBuckets {
+		if value <= durationBuckets[i] {
+			h.buckets[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+type requestKey struct {
+	script string
+	code   int
+}
+
+// metrics collects the metrics of a server.
+type metrics struct {
+	mtx         sync.Mutex
+	requests    map[requestKey]uint64
+	durations   map[string]*histogram
+	errors      map[string]uint64
+	cacheHits   *atomic.Uint64
+	cacheMisses *atomic.Uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:    map[requestKey]uint64{},
+		durations:   map[string]*histogram{},
+		errors:      map[string]uint64{},
+		cacheHits:   atomic.NewUint64(0),
+		cacheMisses: atomic.NewUint64(0),
+	}
+}
+
+func (m *metrics) observeRequest(script string, code int, duration time.Duration) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.requests[requestKey{script: script, code: code}]++
+
+	h, ok := m.durations[script]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(durationBuckets))}
+		m.durations[script] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+func (m *metrics) scriptError(kind string) {
+	m.mtx.Lock()
+	m.errors[kind]++
+	m.mtx.Unlock()
+}
+
+func (m *metrics) cacheLookup(hit bool) {
+	if hit {
+		m.cacheHits.Inc()
+	} else {
+		m.cacheMisses.Inc()
+	}
+}
+
+// escapeLabel escapes a label value for the text format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i := range keys {
+		pairs[i] = keys[i] + `="` + escapeLabel(labels[keys[i]]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// writeMetrics writes the metrics grouped by name in the
+// Prometheus text exposition format.
+func writeMetrics(w *bufio.Writer, samples []Metric) {
+	written := map[string]bool{}
+	for i := range samples {
+		family := samples[i].Family
+		if len(family) == 0 {
+			family = samples[i].Name
+		}
+
+		if !written[family] {
+			written[family] = true
+			if len(samples[i].Help) > 0 {
+				fmt.Fprintf(w, "# HELP %s %s\n", family, samples[i].Help)
+			}
+			if len(samples[i].Type) > 0 {
+				fmt.Fprintf(w, "# TYPE %s %s\n", family, samples[i].Type)
+			}
+		}
+
+		fmt.Fprintf(w, "%s%s %s\n", samples[i].Name, formatLabels(samples[i].Labels), formatValue(samples[i].Value))
+	}
+}
+
+// collectMetrics returns the samples of all server metrics.
+func (s *Server) collectMetrics() []Metric {
+	m := s.metrics
+	var samples []Metric
+
+	m.mtx.Lock()
+
+	requestKeys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		if requestKeys[i].script == requestKeys[j].script {
+			return requestKeys[i].code < requestKeys[j].code
+		}
+		return requestKeys[i].script < requestKeys[j].script
+	})
+
+	for _, key := range requestKeys {
+		samples = append(samples, Metric{
+			Name:   "why_requests_total",
+			Help:   "Total number of handled requests.",
+			Type:   MetricCounter,
+			Labels: map[string]string{"script": key.script, "code": strconv.Itoa(key.code)},
+			Value:  float64(m.requests[key]),
+		})
+	}
+
+	scripts := make([]string, 0, len(m.durations))
+	for script := range m.durations {
+		scripts = append(scripts, script)
+	}
+	sort.Strings(scripts)
+
+	duration := func(name string, labels map[string]string, value float64) Metric {
+		return Metric{
+			Name:   name,
+			Family: "why_request_duration_seconds",
+			Help:   "Duration of the handled requests in seconds.",
+			Type:   MetricHistogram,
+			Labels: labels,
+			Value:  value,
+		}
+	}
+
+	for _, script := range scripts {
+		h := m.durations[script]
+		for i := range durationBuckets {
+			samples = append(samples, duration("why_request_duration_seconds_bucket", map[string]string{"script": script, "le": formatValue(durationBuckets[i])}, float64(h.buckets[i])))
+		}
+		samples = append(samples,
+			duration("why_request_duration_seconds_bucket", map[string]string{"script": script, "le": "+Inf"}, float64(h.count)),
+			duration("why_request_duration_seconds_sum", map[string]string{"script": script}, h.sum),
+			duration("why_request_duration_seconds_count", map[string]string{"script": script}, float64(h.count)),
+		)
+	}
+
+	kinds := make([]string, 0, len(m.errors))
+	for kind := range m.errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		samples = append(samples, Metric{
+			Name:   "why_script_errors_total",
+			Help:   "Total number of script errors by type.",
+			Type:   MetricCounter,
+			Labels: map[string]string{"type": kind},
+			Value:  float64(m.errors[kind]),
+		})
+	}
+
+	m.mtx.Unlock()
+
+	samples = append(samples,
+		Metric{
+			Name:  "why_script_cache_hits_total",
+			Help:  "Total number of compiled scripts found in the cache.",
+			Type:  MetricCounter,
+			Value: float64(m.cacheHits.Load()),
+		},
+		Metric{
+			Name:  "why_script_cache_misses_total",
+			Help:  "Total number of scripts that needed to be compiled.",
+			Type:  MetricCounter,
+			Value: float64(m.cacheMisses.Load()),
+		},
+		Metric{
+			Name:  "why_script_cache_entries",
+			Help:  "Number of compiled scripts in the cache.",
+			Type:  MetricGauge,
+			Value: float64(s.cache.len()),
+		},
+		Metric{
+			Name:  "why_script_instances_created_total",
+			Help:  "Total number of script instances cloned for the pools.",
+			Type:  MetricCounter,
+			Value: float64(s.cache.created.Load()),
+		},
+		Metric{
+			Name:  "why_script_instances_in_use",
+			Help:  "Number of script instances taken from the pools.",
+			Type:  MetricGauge,
+			Value: float64(s.cache.inUse.Load()),
+		},
+		Metric{
+			Name:  "why_scripts_running",
+			Help:  "Number of currently running script executions.",
+			Type:  MetricGauge,
+			Value: float64(s.executions.len()),
+		},
+	)
+
+	for i := range s.extensions {
+		if ext, ok := s.extensions[i].(MetricsExtension); ok {
+			samples = append(samples, ext.Metrics()...)
+		}
+	}
+
+	return samples
+}
+
+// MetricsHandler returns a handler that exposes the metrics of the
+// server in the Prometheus text exposition format.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		buf := bufio.NewWriter(w)
+		writeMetrics(buf, s.collectMetrics())
+		_ = buf.Flush()
+	})
+}
@@ -0,0 +1,75 @@
+package why
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []Metric
+		want    string
+	}{
+		{
+			name: "counter",
+			samples: []Metric{
+				{Name: "requests_total", Help: "Requests.", Type: MetricCounter, Labels: map[string]string{"code": "200"}, Value: 2},
+				{Name: "requests_total", Help: "Requests.", Type: MetricCounter, Labels: map[string]string{"code": "404"}, Value: 1},
+			},
+			want: "# HELP requests_total Requests.\n# TYPE requests_total counter\nrequests_total{code=\"200\"} 2\nrequests_total{code=\"404\"} 1\n",
+		},
+		{
+			name: "histogram family",
+			samples: []Metric{
+				{Name: "duration_bucket", Family: "duration", Help: "Duration.", Type: MetricHistogram, Labels: map[string]string{"le": "+Inf"}, Value: 1},
+				{Name: "duration_sum", Family: "duration", Help: "Duration.", Type: MetricHistogram, Value: 0.5},
+				{Name: "duration_count", Family: "duration", Help: "Duration.", Type: MetricHistogram, Value: 1},
+			},
+			want: "# HELP duration Duration.\n# TYPE duration histogram\nduration_bucket{le=\"+Inf\"} 1\nduration_sum 0.5\nduration_count 1\n",
+		},
+		{
+			name:    "escaped labels",
+			samples: []Metric{{Name: "info", Labels: map[string]string{"path": "a\"b\\c"}, Value: 1}},
+			want:    "info{path=\"a\\\"b\\\\c\"} 1\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := bufio.NewWriter(&buf)
+			writeMetrics(w, test.samples)
+			_ = w.Flush()
+
+			if buf.String() != test.want {
+				t.Fatalf("expected:\n%s\ngot:\n%s", test.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestDurationHistogramHeaders(t *testing.T) {
+	s := New(&Config{})
+	s.metrics.observeRequest("/index", 200, 20*time.Millisecond)
+	s.metrics.observeRequest("/about", 200, 20*time.Millisecond)
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeMetrics(w, s.collectMetrics())
+	_ = w.Flush()
+	out := buf.String()
+
+	if strings.Count(out, "# TYPE why_request_duration_seconds histogram\n") != 1 {
+		t.Fatalf("expected one histogram TYPE line, got:\n%s", out)
+	}
+	if strings.Count(out, "# HELP why_request_duration_seconds ") != 1 {
+		t.Fatalf("expected one histogram HELP line, got:\n%s", out)
+	}
+	if strings.Contains(out, "# TYPE why_request_duration_seconds_") {
+		t.Fatalf("expected no TYPE lines for the histogram samples, got:\n%s", out)
+	}
+}
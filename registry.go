@@ -0,0 +1,284 @@
+package why
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ExtensionFactory describes a extension that can be created from a
+// named configuration (e.g. from the config file of the server).
+type ExtensionFactory struct {
+	// Type is the name the extension is configured with (e.g. "bbolt").
+	Type string
+
+	// Description is a short description of the extension.
+	Description string
+
+	// Config returns a pointer to a new config struct with the
+	// default values set. The fields can be documented with a "help"
+	// tag, be marked as required with a `required:"true"` tag and as
+	// secret with a `secret:"true"` tag, so they are redacted when
+	// the config is printed. If the config implements ConfigValidator
+	// it will be validated before the extension is created.
+	Config func() interface{}
+
+	// Create creates the extension from the config returned by Config.
+	Create func(conf interface{}) (Extension, error)
+
+	// Declare optionally creates the extension without connecting to
+	// anything (e.g. opening a database). The extension is only used
+	// to declare its variables and modules, so scripts can be checked
+	// without running them. It will never be initialized, hooked or
+	// called, but can be shut down. If Declare is nil, Create is used
+	// instead.
+	Declare func(conf interface{}) (Extension, error)
+}
+
+// ConfigValidator can be implemented by configs of extensions that
+// need to validate more than the required fields. Validate should
+// return a *FieldError if a specific field is invalid.
+type ConfigValidator interface {
+	Validate() error
+}
+
+// FieldError is a error of a specific config field.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field '%s': %s", e.Field, e.Message)
+}
+
+// ConfigField describes a field of a extension config.
+type ConfigField struct {
+	Name     string
+	Type     string
+	Default  string
+	Required bool
+	Secret   bool
+	Help     string
+}
+
+var (
+	factoriesMtx sync.RWMutex
+	factories    = map[string]ExtensionFactory{}
+)
+
+// RegisterExtensionFactory makes a extension available by its type. This
+// is usually called in the init function of the extension package. If a
+// factory with the same type is already registered it panics.
+func RegisterExtensionFactory(f ExtensionFactory) {
+	factoriesMtx.Lock()
+	defer factoriesMtx.Unlock()
+
+	if f.Config == nil || f.Create == nil {
+		panic("why: extension factory '" + f.Type + "' is missing Config or Create")
+	}
+
+	if _, ok := factories[f.Type]; ok {
+		panic("why: extension factory '" + f.Type + "' registered twice")
+	}
+
+	factories[f.Type] = f
+}
+
+// ExtensionFactories returns all registered extension factories
+// sorted by their type.
+func ExtensionFactories() []ExtensionFactory {
+	factoriesMtx.RLock()
+	defer factoriesMtx.RUnlock()
+
+	list := make([]ExtensionFactory, 0, len(factories))
+	for _, f := range factories {
+		list = append(list, f)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Type < list[j].Type
+	})
+
+	return list
+}
+
+// ExtensionFactoryByType returns the registered factory of the type.
+func ExtensionFactoryByType(typ string) (ExtensionFactory, bool) {
+	factoriesMtx.RLock()
+	defer factoriesMtx.RUnlock()
+
+	f, ok := factories[typ]
+	return f, ok
+}
+
+// ValidateExtensionConfig checks the config of a extension of the given
+// type without creating the extension.
+func ValidateExtensionConfig(typ string, conf json.RawMessage) error {
+	f, ok := ExtensionFactoryByType(typ)
+	if !ok {
+		return errors.Errorf("extension '%s' not found", typ)
+	}
+
+	if _, err := f.decodeConfig(conf); err != nil {
+		return errors.Wrapf(err, "extension '%s'", typ)
+	}
+
+	return nil
+}
+
+// CreateExtension creates a extension of the given type from a json
+// config object. Unknown fields, wrong types and missing required
+// fields are reported with the name of the offending field.
+func CreateExtension(typ string, conf json.RawMessage) (Extension, error) {
+	f, ok := ExtensionFactoryByType(typ)
+	if !ok {
+		return nil, errors.Errorf("extension '%s' not found", typ)
+	}
+
+	c, err := f.decodeConfig(conf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "extension '%s'", typ)
+	}
+
+	ext, err := f.Create(c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "extension '%s'", typ)
+	}
+
+	return ext, nil
+}
+
+// DeclareExtension works like CreateExtension, but uses the Declare
+// function of the factory if available. The returned extension can
+// only be used to check scripts and must not be initialized.
+func DeclareExtension(typ string, conf json.RawMessage) (Extension, error) {
+	f, ok := ExtensionFactoryByType(typ)
+	if !ok {
+		return nil, errors.Errorf("extension '%s' not found", typ)
+	}
+
+	if f.Declare == nil {
+		return CreateExtension(typ, conf)
+	}
+
+	c, err := f.decodeConfig(conf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "extension '%s'", typ)
+	}
+
+	ext, err := f.Declare(c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "extension '%s'", typ)
+	}
+
+	return ext, nil
+}
+
+// decodeConfig decodes and validates the config of the extension.
+func (f ExtensionFactory) decodeConfig(data json.RawMessage) (interface{}, error) {
+	c := f.Config()
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		return nil, errors.New("config must be a object with named fields, positional arrays are not supported anymore")
+	}
+
+	if len(data) > 0 && !bytes.Equal(data, []byte("null")) {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+
+		if err := dec.Decode(c); err != nil {
+			switch err := err.(type) {
+			case *json.UnmarshalTypeError:
+				return nil, &FieldError{Field: err.Field, Message: "expected " + err.Type.String() + " but got " + err.Value}
+			case *json.SyntaxError:
+				return nil, errors.Wrapf(err, "invalid json at offset %d", err.Offset)
+			}
+
+			// Unknown fields are only reported as plain error by
+			// encoding/json, so the field name is extracted.
+			if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+				return nil, &FieldError{Field: strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`), Message: "unknown field"}
+			}
+
+			return nil, err
+		}
+	}
+
+	// Check the required fields.
+	v := reflect.Indirect(reflect.ValueOf(c))
+	if v.Kind() == reflect.Struct {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.Tag.Get("required") == "true" && isZero(v.Field(i)) {
+				return nil, &FieldError{Field: field.Name, Message: "is required"}
+			}
+		}
+	}
+
+	if validator, ok := c.(ConfigValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Schema returns the fields of the config of the extension.
+func (f ExtensionFactory) Schema() []ConfigField {
+	v := reflect.Indirect(reflect.ValueOf(f.Config()))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := make([]ConfigField, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+
+		cf := ConfigField{
+			Name:     field.Name,
+			Type:     typeName(field.Type),
+			Required: field.Tag.Get("required") == "true",
+			Secret:   field.Tag.Get("secret") == "true",
+			Help:     field.Tag.Get("help"),
+		}
+
+		if !isZero(v.Field(i)) {
+			if def, err := json.Marshal(v.Field(i).Interface()); err == nil {
+				cf.Default = string(def)
+			}
+		}
+
+		fields = append(fields, cf)
+	}
+
+	return fields
+}
+
+// typeName returns a readable name of a config field type.
+func typeName(t reflect.Type) string {
+	switch {
+	case t == reflect.TypeOf(Duration(0)):
+		return "duration"
+	case t.Kind() == reflect.Slice:
+		return "[]" + typeName(t.Elem())
+	case t.Kind() == reflect.Map:
+		return "map[" + typeName(t.Key()) + "]" + typeName(t.Elem())
+	}
+	return t.Kind().String()
+}
+
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
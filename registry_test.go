@@ -0,0 +1,132 @@
+package why
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testExtensionConfig struct {
+	Path    string   `required:"true" help:"path of the file"`
+	Timeout Duration `help:"timeout of a operation"`
+	Size    int
+	Token   string `secret:"true"`
+}
+
+func (c *testExtensionConfig) Validate() error {
+	if c.Size < 0 {
+		return &FieldError{Field: "Size", Message: "can't be negative"}
+	}
+	return nil
+}
+
+var testFactory = ExtensionFactory{
+	Type: "test",
+	Config: func() interface{} {
+		return &testExtensionConfig{Timeout: Duration(time.Second)}
+	},
+}
+
+func TestDecodeConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		want      *testExtensionConfig
+		wantField string
+		wantErr   bool
+	}{
+		{
+			name: "valid",
+			data: `{"Path": "db.bolt", "Timeout": "5s", "Size": 10}`,
+			want: &testExtensionConfig{Path: "db.bolt", Timeout: Duration(5 * time.Second), Size: 10},
+		},
+		{
+			name: "defaults",
+			data: `{"Path": "db.bolt"}`,
+			want: &testExtensionConfig{Path: "db.bolt", Timeout: Duration(time.Second)},
+		},
+		{name: "missing required", data: `{"Size": 1}`, wantField: "Path"},
+		{name: "empty config", data: ``, wantField: "Path"},
+		{name: "null config", data: `null`, wantField: "Path"},
+		{name: "unknown field", data: `{"Path": "db.bolt", "Pth": "x"}`, wantField: "Pth"},
+		{name: "wrong type", data: `{"Path": "db.bolt", "Size": "10"}`, wantField: "Size"},
+		{name: "validator", data: `{"Path": "db.bolt", "Size": -1}`, wantField: "Size"},
+		{name: "positional array", data: `["db.bolt"]`, wantErr: true},
+		{name: "invalid json", data: `{"Path": }`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := testFactory.decodeConfig(json.RawMessage(test.data))
+
+			switch {
+			case len(test.wantField) > 0:
+				fe, ok := err.(*FieldError)
+				if !ok || fe.Field != test.wantField {
+					t.Fatalf("expected error of field %s, got %v", test.wantField, err)
+				}
+			case test.wantErr:
+				if err == nil {
+					t.Fatal("expected error")
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !reflect.DeepEqual(c, test.want):
+				t.Fatalf("expected %+v, got %+v", test.want, c)
+			}
+		})
+	}
+}
+
+func TestSchema(t *testing.T) {
+	want := []ConfigField{
+		{Name: "Path", Type: "string", Required: true, Help: "path of the file"},
+		{Name: "Timeout", Type: "duration", Default: `"1s"`, Help: "timeout of a operation"},
+		{Name: "Size", Type: "int"},
+		{Name: "Token", Type: "string", Secret: true},
+	}
+
+	if got := testFactory.Schema(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestDeclareExtension(t *testing.T) {
+	var calls []string
+	factory := func(typ string, withDeclare bool) ExtensionFactory {
+		f := ExtensionFactory{
+			Type:   typ,
+			Config: func() interface{} { return &struct{}{} },
+			Create: func(conf interface{}) (Extension, error) {
+				calls = append(calls, typ+" create")
+				return nil, nil
+			},
+		}
+		if withDeclare {
+			f.Declare = func(conf interface{}) (Extension, error) {
+				calls = append(calls, typ+" declare")
+				return nil, nil
+			}
+		}
+		return f
+	}
+
+	RegisterExtensionFactory(factory("declare-test", true))
+	RegisterExtensionFactory(factory("create-test", false))
+
+	for _, typ := range []string{"declare-test", "create-test"} {
+		if _, err := DeclareExtension(typ, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := DeclareExtension("missing-test", nil); err == nil {
+		t.Fatal("expected error for unknown type")
+	}
+
+	want := []string{"declare-test declare", "create-test create"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+}
@@ -3,14 +3,20 @@ package why
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"io"
+	"io/ioutil"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/d5/tengo/script"
 
@@ -23,31 +29,66 @@ import (
 	"github.com/d5/tengo/stdlib"
 )
 
-var globalVariables = []string{"http", "PUB_DIR"}
+var globalVariables = []string{"http", "PUB_DIR", dispatchVariable}
 var requestedAbort = errors.New("requested abort")
+var errBodyTooLarge = errors.New("request body too large")
+
+// middlewareFile is the name of the scripts that will run before
+// every script in their directory and all sub-directories.
+const middlewareFile = "_middleware.tengo"
+
+// Middleware wraps a http.Handler to run code before or
+// after the next handler.
+type Middleware func(next http.Handler) http.Handler
 
 // Server represents a instance of the why server.
 type Server struct {
-	conf       *Config
-	running    *atomic.Bool
-	serv       *http.Server
-	extensions []Extension
-	stdModules *objects.ModuleMap
-	bufferPool *sync.Pool
-	cache      *scriptCache
+	conf           *Config
+	running        *atomic.Bool
+	executions     *inFlight
+	initialized    *atomic.Bool
+	initMtx        sync.Mutex
+	servMtx        sync.Mutex
+	shutdownOnce   sync.Once
+	shutdownErr    error
+	serv           *http.Server
+	redirectServ   *http.Server
+	handler        http.Handler
+	middlewares    []Middleware
+	accessLogger   AccessLogger
+	logger         Logger
+	logLevel       LogLevel
+	metrics        *metrics
+	tracer         Tracer
+	internalServ   *http.Server
+	extensions     []Extension
+	extensionNames []string
+	registered     []registeredFuncs
+	stdModules     *objects.ModuleMap
+	moduleNames    map[string]bool
+	bufferPool     *sync.Pool
+	cache          *scriptCache
 }
 
 // New creates a new why server.
 func New(conf *Config) *Server {
 	s := &Server{
-		running: atomic.NewBool(false),
-		conf:    conf,
+		running:     atomic.NewBool(false),
+		executions:  &inFlight{},
+		metrics:     newMetrics(),
+		initialized: atomic.NewBool(false),
+		conf:        conf,
 		bufferPool: &sync.Pool{
 			New: func() interface{} {
 				return new(bytes.Buffer)
 			},
 		},
-		stdModules: stdlib.GetModuleMap(stdlib.AllModuleNames()...),
+		stdModules:  stdlib.GetModuleMap(stdlib.AllModuleNames()...),
+		moduleNames: map[string]bool{},
+	}
+
+	for _, name := range stdlib.AllModuleNames() {
+		s.moduleNames[name] = true
 	}
 
 	// Create a script cache that will cache compiled scripts.
@@ -60,12 +101,33 @@ func New(conf *Config) *Server {
 		}
 
 		for i := range s.extensions {
-			for j := range s.extensions[i].Vars() {
-				_ = sc.Add(s.extensions[i].Vars()[j], "")
+			for _, name := range s.extensionVars(i) {
+				_ = sc.Add(name, "")
 			}
 		}
 	})
 
+	s.handler = http.HandlerFunc(s.handle)
+	s.logger = stdLogger{}
+
+	// Fall back to the info level if the configured level is unknown.
+	if level, err := ParseLogLevel(conf.LogLevel); err == nil {
+		s.logLevel = level
+	} else {
+		log.Printf("%v, using info level\n", err)
+		s.logLevel = LogInfo
+	}
+
+	// Build the app module one time, so it doesn't need to
+	// be converted on every request.
+	app, err := newAppModule(conf)
+	if err != nil {
+		log.Printf("Invalid AppConfig: %v\n", err)
+		app, _ = newAppModule(&Config{AppEnv: conf.AppEnv})
+	}
+	s.moduleNames[appModule] = true
+	s.stdModules.AddBuiltinModule(appModule, app)
+
 	return s
 }
 
@@ -73,17 +135,306 @@ func New(conf *Config) *Server {
 // This function can only be called before when the server
 // is not running.
 func (s *Server) AddExtension(e Extension) error {
+	return s.AddExtensionAs("", e)
+}
+
+// AddExtensionAs adds a new instance of a extension to the server.
+// The registered functions and the module of the extension will use
+// the instance name instead of the name returned by the extension,
+// so multiple instances of the same extension can be added. If name
+// is empty the name of the extension is used. Variables that are
+// already used by the server or another extension result in a error.
+// This function can only be called when the server is not running.
+func (s *Server) AddExtensionAs(name string, e Extension) error {
 	if s.running.Load() {
 		return errors.New("can't add extension while running")
 	}
+
+	display := e.Name()
+	if len(name) > 0 {
+		if !isIdentifier(name) {
+			return errors.Errorf("instance name '%s' of extension '%s' is not a valid variable name", name, display)
+		}
+		display += " (" + name + ")"
+	}
+
+	// If modules are preferred the registered functions of module
+	// extensions won't be added as global variable.
+	mod, isModule := e.(ModuleExtension)
+	registered := registeredFuncs{}
+	if reg, ok := e.(RegisterExtension); ok && !(isModule && s.conf.ModuleExtensions) {
+		registered.name, registered.funcs = reg.Register()
+		if len(name) > 0 {
+			registered.name = name
+		}
+	}
+
+	// Check that the variables don't clash with the ones of
+	// the server or the other extensions.
+	used := s.reservedVariables()
+	vars := e.Vars()
+	if len(registered.name) > 0 {
+		vars = append(append([]string(nil), vars...), registered.name)
+	}
+	for _, v := range vars {
+		if used[v] {
+			return errors.Errorf("variable '%s' of extension '%s' is already used", v, display)
+		}
+		used[v] = true
+	}
+
+	// Register the extension as importable module. Module names
+	// need to be unique, so they can't clash with the standard
+	// library or other extensions.
+	if isModule {
+		modName, attrs := mod.Module()
+		if len(name) > 0 {
+			modName = name
+		}
+		if s.moduleNames[modName] {
+			return errors.Errorf("module '%s' of extension '%s' already exists", modName, display)
+		}
+		s.moduleNames[modName] = true
+		s.stdModules.AddBuiltinModule(modName, attrs)
+	}
+
 	s.extensions = append(s.extensions, e)
+	s.extensionNames = append(s.extensionNames, display)
+	s.registered = append(s.registered, registered)
+
+	return nil
+}
+
+// keywords are the reserved words of tengo that can't be
+// used as variable names.
+var keywords = map[string]bool{
+	"break": true, "continue": true, "else": true, "for": true, "func": true,
+	"error": true, "immutable": true, "if": true, "return": true, "export": true,
+	"true": true, "false": true, "in": true, "undefined": true, "import": true,
+}
+
+// isIdentifier checks if name can be used as variable name in scripts.
+func isIdentifier(name string) bool {
+	if keywords[name] {
+		return false
+	}
+
+	for i, c := range name {
+		if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return len(name) > 0
+}
+
+// extensionName returns the name of the extension at index i
+// including its instance name.
+func (s *Server) extensionName(i int) string {
+	return s.extensionNames[i]
+}
+
+// extensionVars returns the names of all global variables the
+// extension at index i will set, including registered functions.
+func (s *Server) extensionVars(i int) []string {
+	vars := s.extensions[i].Vars()
+	if len(s.registered[i].name) > 0 {
+		vars = append(append([]string(nil), vars...), s.registered[i].name)
+	}
+	return vars
+}
+
+// Use adds middlewares that wrap the handling of each request.
+// The first added middleware will be the outermost one. This
+// function can only be called when the server is not running.
+func (s *Server) Use(middlewares ...Middleware) error {
+	if s.running.Load() {
+		return errors.New("can't add middleware while running")
+	}
+
+	s.middlewares = append(s.middlewares, middlewares...)
+
+	s.handler = http.HandlerFunc(s.handle)
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		s.handler = s.middlewares[i](s.handler)
+	}
+
+	return nil
+}
+
+// SetAccessLogger sets the logger that will receive a entry for
+// each handled request. This function can only be called when the
+// server is not running.
+func (s *Server) SetAccessLogger(logger AccessLogger) error {
+	if s.running.Load() {
+		return errors.New("can't set access logger while running")
+	}
+	s.accessLogger = logger
+	return nil
+}
+
+// SetLogger sets the logger that receives the log messages of
+// the scripts. By default messages are written through the
+// standard log package. This function can only be called when
+// the server is not running.
+func (s *Server) SetLogger(logger Logger) error {
+	if s.running.Load() {
+		return errors.New("can't set logger while running")
+	}
+	s.logger = logger
+	return nil
+}
+
+// SetTracer sets the tracer that records the spans of each request.
+// This function can only be called when the server is not running.
+func (s *Server) SetTracer(tracer Tracer) error {
+	if s.running.Load() {
+		return errors.New("can't set tracer while running")
+	}
+	s.tracer = tracer
+	return nil
+}
+
+// ServeHTTP handles a request with all the middlewares. This
+// makes it possible to use the server as a http.Handler. If
+// Start isn't used Init needs to be called before serving.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	var tc TraceContext
+	var hasTrace bool
+	if s.conf.PropagateTraceContext {
+		if tc, hasTrace = parseTraceParent(r.Header.Get("traceparent")); hasTrace {
+			r = r.WithContext(context.WithValue(r.Context(), traceContextKey{}, tc))
+		}
+	}
+
+	info := &requestInfo{id: requestID(r, tc, hasTrace)}
+	if s.conf.debugToolbar() {
+		info.debug = newDebugInfo(start)
+	}
+	r = withRequestInfo(r, info)
+	w.Header().Set(RequestIDHeader, info.id)
+
+	ctx, span := s.startSpan(r.Context(), "request")
+	span.SetAttribute("request_id", info.id)
+	span.SetAttribute("method", r.Method)
+	span.SetAttribute("path", r.URL.Path)
+	r = r.WithContext(ctx)
+
+	rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	s.serve(rec, r)
+
+	span.SetAttribute("status", rec.status)
+	span.End()
+
+	duration := time.Since(start)
+	s.metrics.observeRequest(info.script, rec.status, duration)
+
+	if s.accessLogger != nil {
+		s.accessLogger.LogAccess(&AccessEntry{
+			Time:       start,
+			RequestID:  info.id,
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Path:       r.URL.Path,
+			Proto:      r.Proto,
+			Script:     info.script,
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			Duration:   duration,
+			Cache:      info.cache,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		})
+	}
+}
+
+// serve strips the base path and passes the request
+// through the middleware chain.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if base := s.conf.basePath(); len(base) > 0 {
+		path := strings.TrimPrefix(r.URL.Path, base)
+		if len(path) == len(r.URL.Path) || (len(path) > 0 && path[0] != '/') {
+			http.NotFound(w, r)
+			return
+		}
+
+		if len(path) == 0 {
+			path = "/"
+		}
+
+		// Shallow copy the request so that the path can be changed
+		// without affecting handlers outside of the server.
+		stripped := new(http.Request)
+		*stripped = *r
+		stripped.URL = new(url.URL)
+		*stripped.URL = *r.URL
+		stripped.URL.Path = path
+		stripped.URL.RawPath = ""
+		r = stripped
+	}
+
+	s.handler.ServeHTTP(w, r)
+}
+
+// Mount registers the server on the mux under the configured
+// BasePath. This way the server can be used alongside other
+// handlers or other why servers with different PublicDirs.
+func (s *Server) Mount(mux *http.ServeMux) {
+	base := s.conf.basePath()
+	mux.Handle(base+"/", s)
+	if len(base) > 0 {
+		mux.Handle(base, s)
+	}
+}
+
+// Init initializes all the extensions. This is done by Start,
+// but needs to be called manually if the server is only used
+// as http.Handler. Calling Init multiple times or after the
+// server was shut down has no effect.
+func (s *Server) Init() error {
+	// Shutdown waits for the lock, so extensions aren't shut
+	// down while they are initialized.
+	s.initMtx.Lock()
+	defer s.initMtx.Unlock()
+
+	if s.executions.isDraining() || !s.initialized.CAS(false, true) {
+		return nil
+	}
+
+	for i := range s.extensions {
+		if err := s.extensions[i].Init(); err != nil {
+			s.initialized.Store(false)
+			return errors.Wrapf(err, "error while init of '%s'", s.extensionName(i))
+		}
+		log.Printf("Extension '%s' loaded.\n", s.extensionName(i))
+	}
+
 	return nil
 }
 
 // Start starts the server and binds it to the
 // given address.
 func (s *Server) Start(address string) error {
+	if s.running.Load() {
+		return errors.New("server already running")
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return s.StartListener(listener)
+}
+
+// StartListener starts the server and serves the requests accepted
+// by the given listener. This can be used to serve on unix sockets
+// or inherited listeners. The listener will be closed on shutdown.
+func (s *Server) StartListener(listener net.Listener) error {
 	if !s.running.CAS(false, true) {
+		_ = listener.Close()
 		return errors.New("server already running")
 	}
 	defer func() {
@@ -98,35 +449,173 @@ func (s *Server) Start(address string) error {
 └─────────────────────────┘
 `)
 
-	for i := range s.extensions {
-		if err := s.extensions[i].Init(); err != nil {
-			return errors.Wrapf(err, "error while init of '%s'", s.extensions[i].Name())
-		} else {
-			log.Printf("Extension '%s' loaded.\n", s.extensions[i].Name())
+	if err := s.Init(); err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	address := listener.Addr().String()
+	serv := &http.Server{
+		Addr:           address,
+		Handler:        s,
+		ReadTimeout:    time.Duration(s.conf.ReadTimeout),
+		WriteTimeout:   time.Duration(s.conf.WriteTimeout),
+		IdleTimeout:    time.Duration(s.conf.IdleTimeout),
+		MaxHeaderBytes: s.conf.MaxHeaderBytes,
+	}
+
+	if s.conf.DisableHTTP2 {
+		// A non-nil empty map disables the automatic HTTP/2 setup.
+		serv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	var internalServ, redirectServ *http.Server
+	if len(s.conf.InternalAddress) > 0 {
+		internalServ = &http.Server{Addr: s.conf.InternalAddress, Handler: s.InternalHandler()}
+	} else if s.internalEnabled() && !s.conf.ExposeInternal {
+		log.Println("Internal endpoints are configured, but neither InternalAddress nor ExposeInternal is set.")
+	}
+
+	if s.conf.tlsEnabled() {
+		certs, err := newCertReloader(s.conf.TLSCertFile, s.conf.TLSKeyFile)
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+
+		serv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+
+		if len(s.conf.RedirectAddress) > 0 {
+			redirectServ = &http.Server{
+				Addr:         s.conf.RedirectAddress,
+				Handler:      redirectToHTTPS(address),
+				ReadTimeout:  time.Duration(s.conf.ReadTimeout),
+				WriteTimeout: time.Duration(s.conf.WriteTimeout),
+			}
 		}
 	}
 
-	s.serv = &http.Server{Addr: address}
-	http.HandleFunc("/", s.handle)
+	// The servers are set up completely before they are published,
+	// because Shutdown can be called from another goroutine. If it
+	// already ran, it didn't see the servers and they aren't started.
+	s.servMtx.Lock()
+	if s.executions.isDraining() {
+		s.servMtx.Unlock()
+		_ = listener.Close()
+		log.Println("Server was shut down while starting.")
+		return nil
+	}
+	s.serv, s.internalServ, s.redirectServ = serv, internalServ, redirectServ
+	s.servMtx.Unlock()
+
+	if internalServ != nil {
+		go func() {
+			if err := internalServ.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Internal server stopped: %v\n", err)
+			}
+		}()
+	}
 
-	log.Println("Server started.")
-	return s.serv.ListenAndServe()
+	if redirectServ != nil {
+		go func() {
+			if err := redirectServ.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Redirect server stopped: %v\n", err)
+			}
+		}()
+	}
+
+	if !s.conf.tlsEnabled() {
+		log.Println("Server started.")
+		return ignoreClosed(serv.Serve(listener))
+	}
+
+	log.Println("Server started with TLS.")
+	return ignoreClosed(serv.ServeTLS(listener, "", ""))
 }
 
-// Shutdown will try to shut the server down.
+// ignoreClosed filters the error that is returned by the http
+// server after a regular shutdown.
+func ignoreClosed(err error) error {
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown will try to shut the server down. It stops accepting new
+// requests and waits up to the configured ShutdownTimeout for running
+// scripts to finish. The extensions will be shut down afterwards in the
+// reverse order they were added in.
 func (s *Server) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.conf.shutdownTimeout())
+	defer cancel()
+
+	return s.ShutdownContext(ctx)
+}
+
+// ShutdownContext works like Shutdown, but waits for running scripts
+// until the given context is done instead of the ShutdownTimeout. The
+// server is only shut down once, later calls return the result of the
+// first one.
+func (s *Server) ShutdownContext(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.shutdownErr = s.shutdown(ctx)
+	})
+	return s.shutdownErr
+}
+
+func (s *Server) shutdown(ctx context.Context) error {
 	log.Println("Server shutting down...")
+	s.executions.drain()
 
-	defer func() {
-		for i := range s.extensions {
-			log.Printf("Extension '%s' shutting down.\n", s.extensions[i].Name())
-			_ = s.extensions[i].Shutdown()
+	var result error
+
+	s.servMtx.Lock()
+	serv, internalServ, redirectServ := s.serv, s.internalServ, s.redirectServ
+	s.servMtx.Unlock()
+
+	if redirectServ != nil {
+		_ = redirectServ.Close()
+	}
+
+	if internalServ != nil {
+		_ = internalServ.Close()
+	}
+
+	if serv != nil {
+		if err := serv.Shutdown(ctx); err != nil {
+			result = errors.Wrap(err, "error while shutting down http server")
 		}
-		log.Printf("Server finished shutting down.")
-	}()
+	}
+
+	// Scripts could still be running if the server is used as
+	// http.Handler or the http server shutdown timed out.
+	if err := s.executions.wait(ctx); err != nil {
+		log.Println("Timeout while waiting for running scripts.")
+		if result == nil {
+			result = errors.Wrap(err, "error while waiting for running scripts")
+		}
+	}
+
+	// Wait for a running Init before the extensions are shut down.
+	s.initMtx.Lock()
+	defer s.initMtx.Unlock()
 
-	ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
-	return s.serv.Shutdown(ctx)
+	for i := len(s.extensions) - 1; i >= 0; i-- {
+		log.Printf("Extension '%s' shutting down.\n", s.extensionName(i))
+		if err := s.extensions[i].Shutdown(); err != nil {
+			log.Printf("Error while shutting down extension '%s': %v\n", s.extensionName(i), err)
+			if result == nil {
+				result = errors.Wrapf(err, "error while shutting down '%s'", s.extensionName(i))
+			}
+		}
+	}
+
+	log.Printf("Server finished shutting down.")
+	return result
 }
 
 func (s *Server) error(w http.ResponseWriter, err error, code int) {
@@ -137,7 +626,180 @@ func (s *Server) error(w http.ResponseWriter, err error, code int) {
 	}
 }
 
+// readBody reads the complete body of the request up to limit bytes
+// and replaces the body of the request with a reader over the read
+// data, so that it can be consumed again.
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	// Read one byte more than allowed to know if the limit was
+	// exceeded, so it can be told apart from other read errors.
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, errBodyTooLarge
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// reservedVariables returns the names of all global variables
+// that are set by the server or the extensions.
+func (s *Server) reservedVariables() map[string]bool {
+	reserved := map[string]bool{}
+	for i := range globalVariables {
+		reserved[globalVariables[i]] = true
+	}
+
+	for i := range s.extensions {
+		for _, name := range s.extensionVars(i) {
+			reserved[name] = true
+		}
+	}
+
+	return reserved
+}
+
+// middlewareScripts returns the paths of all middleware scripts that apply
+// to the given script path, ordered from the root of the public dir
+// down to the directory of the script. The paths are relative to the
+// public dir.
+func (s *Server) middlewareScripts(path string) []string {
+	dirs := []string{""}
+	if dir := strings.Trim(filepath.ToSlash(filepath.Dir(filepath.Clean("/"+path))), "/"); len(dir) > 0 {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			dirs = append(dirs, filepath.Join(dirs[len(dirs)-1], parts[i]))
+		}
+	}
+
+	var found []string
+	for i := range dirs {
+		file := "/" + filepath.ToSlash(filepath.Join(dirs[i], middlewareFile))
+		if stat, err := os.Stat(filepath.Join(s.conf.PublicDir, file)); err == nil && !stat.IsDir() {
+			found = append(found, file)
+		}
+	}
+
+	return found
+}
+
+// runScript transpiles, compiles and runs the script from the given
+// reader in the context of the script instance. The path of the script
+// is used to tag log messages.
+func (s *Server) runScript(si *scriptInstance, scriptPath string, file io.Reader) error {
+	ctx, scriptSpan := s.startSpan(si.req.Context(), "script")
+	scriptSpan.SetAttribute("script", scriptPath)
+	defer scriptSpan.End()
+
+	// transpile html containing tengo scripts to a complete tengo script.
+	transpiled := s.bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		transpiled.Reset()
+		s.bufferPool.Put(transpiled)
+	}()
+
+	_, span := s.startSpan(ctx, "transpile")
+	err := Transpile(file, transpiled)
+	span.End()
+	if err != nil {
+		s.metrics.scriptError(errorTranspile)
+		return err
+	}
+
+	// Get a instance from cache or compile the script.
+	_, span = s.startSpan(ctx, "cache_lookup")
+	back, sc, hit := s.cache.lookup(transpiled.Bytes())
+	span.SetAttribute("hit", hit)
+	span.End()
+
+	if !hit {
+		_, span = s.startSpan(ctx, "compile")
+		back, sc, err = s.cache.store(scriptPath, transpiled.Bytes())
+		span.End()
+		if err != nil {
+			s.metrics.scriptError(errorCompile)
+			return err
+		}
+	}
+	s.metrics.cacheLookup(hit)
+
+	if info := getRequestInfo(si.req); !hit {
+		info.cache = "miss"
+	} else if len(info.cache) == 0 {
+		info.cache = "hit"
+	}
+
+	defer func() {
+		s.cache.put(back, sc)
+	}()
+
+	// Replace all the variables with the correct ones for this request.
+	si.script = sc
+	_ = sc.Set("PUB_DIR", s.conf.PublicDir)
+	if err := addHTTP(si, s.logObject(si, scriptPath)); err != nil {
+		return err
+	}
+	_ = sc.Set(dispatchVariable, &objects.UserFunction{Value: dispatch(si)})
+
+	// Set the registered extension functions.
+	for i := range s.registered {
+		if len(s.registered[i].name) > 0 {
+			if err := sc.Set(s.registered[i].name, si.registered[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Call all extension hooks.
+	for i := range s.extensions {
+		_, span = s.startSpan(ctx, "extension_hook")
+		span.SetAttribute("extension", s.extensionName(i))
+		err := s.extensions[i].Hook(sc, si.buf, si.respWriter, si.req)
+		span.End()
+		if err != nil {
+			s.metrics.scriptError(errorExtension)
+			return err
+		}
+	}
+
+	// Run the script and check the error. If the error is a
+	// requested abort we won't treat it as error. A requested
+	// error will be thrown by using http.die().
+	_, span = s.startSpan(ctx, "run")
+	err = sc.Run()
+	span.End()
+	if err != nil && !strings.Contains(err.Error(), requestedAbort.Error()) {
+		s.metrics.scriptError(errorRuntime)
+		return err
+	}
+
+	if info := getRequestInfo(si.req); info.debug != nil {
+		info.debug.recordVariables(scriptPath, sc, s.reservedVariables())
+	}
+
+	return nil
+}
+
 func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	// The internal endpoints are only served here if they are exposed,
+	// so they are behind the BasePath and the middlewares.
+	if s.conf.ExposeInternal && s.serveInternal(w, r) {
+		return
+	}
+
 	// If the path contains '..' a attacker could traverse upper directories
 	// and access files that could contain sensitive information. If a '..'
 	// appears in the path we will return a error.
@@ -153,12 +815,21 @@ func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
 		path += ".tengo"
 	}
 
+	// Middleware scripts can't be requested directly.
+	if filepath.Base(path) == middlewareFile {
+		s.error(w, errors.New("middleware can't be requested"), http.StatusNotFound)
+		return
+	}
+
 	// Read the target file.
 	file, err := os.OpenFile(filepath.Join(s.conf.PublicDir, path), os.O_RDONLY, 0666)
 	if err != nil {
 		s.error(w, err, http.StatusNotFound)
 		return
 	}
+	defer file.Close()
+
+	getRequestInfo(r).script = path
 
 	// If it it's not a .tengo script we just return the content of the file.
 	if !strings.HasSuffix(path, ".tengo") {
@@ -167,20 +838,30 @@ func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// transpile html containing tengo scripts to a complete tengo script.
-	transpiled := s.bufferPool.Get().(*bytes.Buffer)
-	defer func() {
-		transpiled.Reset()
-		s.bufferPool.Put(transpiled)
-	}()
+	// Don't start new scripts while the server is shutting down.
+	if !s.executions.tryAdd() {
+		s.error(w, errors.New("server is shutting down"), http.StatusServiceUnavailable)
+		return
+	}
+	defer s.executions.done()
 
-	if err := Transpile(file, transpiled); err != nil {
-		s.error(w, err, http.StatusInternalServerError)
+	// Read the body once so that the script can access it multiple
+	// times, even after the form was parsed.
+	body, err := readBody(r, s.conf.maxBodySize())
+	if err == errBodyTooLarge {
+		s.error(w, err, http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		s.error(w, err, http.StatusBadRequest)
 		return
 	}
 
 	// Parse POST form.
 	_ = r.ParseForm()
+	if isMultipart(r) {
+		_ = r.ParseMultipartForm(s.conf.maxBodySize())
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
 
 	// Create final buffer where the html will be written to before
 	// writing to the response.
@@ -190,52 +871,81 @@ func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
 		s.bufferPool.Put(buf)
 	}()
 
-	// Compile the script or get a instance from cache.
-	back, sc, err := s.cache.get(transpiled.Bytes())
-	if err != nil {
-		s.error(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	defer func() {
-		s.cache.put(back, sc)
-	}()
-
 	// The final status code.
 	statusCode := http.StatusOK
 
 	// Contains data about the request. Besides writing to the buffer
-	// and the responseWriter, the script may set the status code.
+	// and the responseWriter, the script may set the status code. The
+	// instance is shared between the middlewares and the page script.
 	si := &scriptInstance{
-		script:     sc,
 		buf:        buf,
 		req:        r,
+		body:       body,
 		statusCode: &statusCode,
 		respWriter: w,
+		basePath:   s.conf.basePath(),
+		locals:     &objects.Map{Value: map[string]objects.Object{}},
+	}
+	si.ctx = &RequestContext{
+		Request:  r,
+		Response: w,
+		Writer:   buf,
+		Locals:   si.locals,
 	}
 
-	// Replace all the variables with the correct ones for this request.
-	_ = sc.Set("PUB_DIR", s.conf.PublicDir)
-	err = addHTTP(si)
-	if err != nil {
-		s.error(w, err, http.StatusNotFound)
-		return
+	// Bind the registered extension functions to the request one
+	// time, so the middlewares and the page script share them.
+	si.registered = make([]*objects.ImmutableMap, len(s.registered))
+	for i := range s.registered {
+		if len(s.registered[i].name) > 0 {
+			si.registered[i] = s.registered[i].bind(si.ctx)
+		}
 	}
 
-	// Call all extension hooks.
-	for i := range s.extensions {
-		if err := s.extensions[i].Hook(sc, buf, w, r); err != nil {
+	// Let the extensions clean up after the request.
+	var requestErr error
+	defer func() {
+		for i := range s.extensions {
+			if post, ok := s.extensions[i].(PostRequestExtension); ok {
+				post.PostRequest(si.ctx, requestErr)
+			}
+		}
+	}()
+
+	// Run all middlewares that apply to the script. If a middleware
+	// calls http.die() the page script won't be executed.
+	for _, middleware := range s.middlewareScripts(path) {
+		mwFile, err := os.Open(filepath.Join(s.conf.PublicDir, middleware))
+		if err != nil {
+			requestErr = err
 			s.error(w, err, http.StatusInternalServerError)
 			return
 		}
+
+		err = s.runScript(si, middleware, mwFile)
+		_ = mwFile.Close()
+		if err != nil {
+			requestErr = err
+			s.error(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if si.aborted {
+			break
+		}
 	}
 
-	// Run the script and check the error. If the error is a
-	// requested abort we won't treat it as error. A requested
-	// error will be thrown by using http.die().
-	if err := sc.Run(); err != nil && !strings.Contains(err.Error(), requestedAbort.Error()) {
-		s.error(w, err, http.StatusInternalServerError)
-		return
+	if !si.aborted {
+		if err := s.runScript(si, path, file); err != nil {
+			requestErr = err
+			s.error(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Add the debug toolbar in dev mode.
+	if info := getRequestInfo(r); info.debug != nil && isHTMLResponse(w.Header().Get("Content-Type"), buf.Bytes()) {
+		info.debug.inject(buf, info.id, statusCode, info.cache)
 	}
 
 	// Write the response.
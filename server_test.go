@@ -0,0 +1,418 @@
+package why
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d5/tengo/script"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestReadBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    io.Reader
+		limit   int64
+		want    string
+		wantErr error
+		readErr bool
+	}{
+		{name: "empty", body: strings.NewReader(""), limit: 4, want: ""},
+		{name: "below limit", body: strings.NewReader("abc"), limit: 4, want: "abc"},
+		{name: "at limit", body: strings.NewReader("abcd"), limit: 4, want: "abcd"},
+		{name: "over limit", body: strings.NewReader("abcde"), limit: 4, wantErr: errBodyTooLarge},
+		{name: "read error", body: failingReader{}, limit: 4, readErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", test.body)
+
+			data, err := readBody(r, test.limit)
+			if test.readErr {
+				if err == nil || err == errBodyTooLarge {
+					t.Fatalf("expected read error, got %v", err)
+				}
+				return
+			}
+			if err != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if string(data) != test.want {
+				t.Fatalf("expected %q, got %q", test.want, data)
+			}
+
+			// The body can be read again.
+			again, _ := ioutil.ReadAll(r.Body)
+			if !bytes.Equal(again, data) {
+				t.Fatalf("expected body %q to be readable again, got %q", data, again)
+			}
+		})
+	}
+}
+
+// lifecycleExtension records the calls of Init and Shutdown.
+type lifecycleExtension struct {
+	mtx         sync.Mutex
+	events      []string
+	init        func()
+	shutdownErr error
+}
+
+func (e *lifecycleExtension) record(event string) {
+	e.mtx.Lock()
+	e.events = append(e.events, event)
+	e.mtx.Unlock()
+}
+
+func (e *lifecycleExtension) recorded() []string {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return append([]string(nil), e.events...)
+}
+
+func (e *lifecycleExtension) Name() string   { return "lifecycle" }
+func (e *lifecycleExtension) Vars() []string { return nil }
+
+func (e *lifecycleExtension) Init() error {
+	e.record("init")
+	if e.init != nil {
+		e.init()
+	}
+	e.record("init done")
+	return nil
+}
+
+func (e *lifecycleExtension) Shutdown() error {
+	e.record("shutdown")
+	return e.shutdownErr
+}
+
+func (e *lifecycleExtension) Hook(sc *script.Compiled, w io.Writer, resp http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func TestShutdownTwice(t *testing.T) {
+	ext := &lifecycleExtension{shutdownErr: errors.New("close failed")}
+
+	s := New(&Config{})
+	if err := s.AddExtension(ext); err != nil {
+		t.Fatal(err)
+	}
+
+	first := s.Shutdown()
+	if first == nil {
+		t.Fatal("expected the error of the extension")
+	}
+
+	if second := s.Shutdown(); second != first {
+		t.Fatalf("expected the first result %v, got %v", first, second)
+	}
+
+	if events := ext.recorded(); len(events) != 1 || events[0] != "shutdown" {
+		t.Fatalf("expected the extension to be shut down once, got %v", events)
+	}
+}
+
+func TestShutdownWhileStarting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "why")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The extension blocks in Init until the shutdown started.
+	initStarted, release := make(chan struct{}), make(chan struct{})
+	ext := &lifecycleExtension{init: func() {
+		close(initStarted)
+		<-release
+	}}
+
+	s := New(&Config{PublicDir: dir, MetricsPath: "/metrics", InternalAddress: "127.0.0.1:0"})
+	if err := s.AddExtension(ext); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		started <- s.StartListener(listener)
+	}()
+	<-initStarted
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.ShutdownContext(context.Background())
+	}()
+
+	// Let the shutdown run up to the extensions before Init finishes.
+	for !s.executions.isDraining() {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	for name, done := range map[string]chan error{"StartListener": started, "Shutdown": stopped} {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%s returned error: %v", name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s didn't return", name)
+		}
+	}
+
+	want := []string{"init", "init done", "shutdown"}
+	if events := ext.recorded(); strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, events)
+	}
+
+	if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		_ = conn.Close()
+		t.Fatal("expected the listener to be closed")
+	}
+}
+
+func TestIsIdentifier(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "db", want: true},
+		{name: "_db2", want: true},
+		{name: "cache_2", want: true},
+		{name: "änderung", want: true},
+		{name: ""},
+		{name: "2db"},
+		{name: "my-db"},
+		{name: "my db"},
+		{name: "func"},
+		{name: "import"},
+		{name: "if"},
+		{name: "undefined"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isIdentifier(test.name); got != test.want {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+// publicDir creates a temporary public dir that contains the files.
+// The returned function removes the dir again.
+func publicDir(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "why")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir, func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestMiddlewareScripts(t *testing.T) {
+	dir, cleanup := publicDir(t, map[string]string{
+		"_middleware.tengo":         `<!? http.write("root,"); http.LOCALS.user = "bob" ?!>`,
+		"index.tengo":               `<!? http.write("index") ?!>`,
+		"admin/_middleware.tengo":   `<!? http.write("admin,") ?!>`,
+		"admin/deep/page.tengo":     `<!? http.write("page:" + http.LOCALS.user) ?!>`,
+		"private/_middleware.tengo": `<!? http.status_code(403); http.write("denied"); http.die() ?!>`,
+		"private/page.tengo":        `<!? http.write("secret") ?!>`,
+	})
+	defer cleanup()
+
+	s := New(&Config{PublicDir: dir})
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{path: "/index", code: http.StatusOK, body: "root,index"},
+		{path: "/admin/deep/page", code: http.StatusOK, body: "root,admin,page:bob"},
+		{path: "/private/page", code: http.StatusForbidden, body: "root,denied"},
+		{path: "/_middleware", code: http.StatusNotFound},
+		{path: "/_middleware.tengo", code: http.StatusNotFound},
+		{path: "/admin/_middleware.tengo", code: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			if rec.Code != test.code {
+				t.Fatalf("expected status %d, got %d", test.code, rec.Code)
+			}
+			if body := rec.Body.String(); len(test.body) > 0 && body != test.body {
+				t.Fatalf("expected body %q, got %q", test.body, body)
+			}
+		})
+	}
+}
+
+func TestUseOrder(t *testing.T) {
+	dir, cleanup := publicDir(t, map[string]string{
+		"index.tengo": `<!? http.write("index") ?!>`,
+	})
+	defer cleanup()
+
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+">")
+				next.ServeHTTP(w, r)
+				calls = append(calls, "<"+name)
+			})
+		}
+	}
+
+	s := New(&Config{PublicDir: dir})
+	if err := s.Use(record("a"), record("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Use(record("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/index", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "index" {
+		t.Fatalf("expected the script to run, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	want := []string{"a>", "b>", "c>", "<c", "<b", "<a"}
+	if strings.Join(calls, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	dir, cleanup := publicDir(t, map[string]string{
+		"index.tengo": `<!? http.write(http.base_path, " ", http.path, " ", http.url_for("/page")) ?!>`,
+	})
+	defer cleanup()
+
+	tests := []struct {
+		base string
+		path string
+		code int
+		seen string
+		body string
+	}{
+		{base: "/app", path: "/app/index", code: http.StatusOK, seen: "/index", body: "/app /index /app/page"},
+		{base: "app/", path: "/app/index", code: http.StatusOK, seen: "/index", body: "/app /index /app/page"},
+		{base: "/app", path: "/app", code: http.StatusNotFound, seen: "/"},
+		{base: "/app", path: "/app/", code: http.StatusNotFound, seen: "/"},
+		{base: "/app", path: "/application/index", code: http.StatusNotFound},
+		{base: "/app", path: "/index", code: http.StatusNotFound},
+		{base: "", path: "/index", code: http.StatusOK, seen: "/index", body: " /index /page"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.base+" "+test.path, func(t *testing.T) {
+			// Record the path that reaches the middlewares.
+			var seen string
+			s := New(&Config{PublicDir: dir, BasePath: test.base})
+			_ = s.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					seen = r.URL.Path
+					next.ServeHTTP(w, r)
+				})
+			})
+
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			if rec.Code != test.code {
+				t.Fatalf("expected status %d, got %d", test.code, rec.Code)
+			}
+			if seen != test.seen {
+				t.Fatalf("expected stripped path %q, got %q", test.seen, seen)
+			}
+			if body := rec.Body.String(); len(test.body) > 0 && body != test.body {
+				t.Fatalf("expected body %q, got %q", test.body, body)
+			}
+		})
+	}
+}
+
+func TestMount(t *testing.T) {
+	dir, cleanup := publicDir(t, map[string]string{
+		"index.tengo": `<!? http.write(http.path) ?!>`,
+	})
+	defer cleanup()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("other"))
+	})
+	New(&Config{PublicDir: dir, BasePath: "/app"}).Mount(mux)
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{path: "/app/index", code: http.StatusOK, body: "/index"},
+		{path: "/app", code: http.StatusNotFound},
+		{path: "/index", code: http.StatusOK, body: "other"},
+		{path: "/application", code: http.StatusOK, body: "other"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			if rec.Code != test.code {
+				t.Fatalf("expected status %d, got %d", test.code, rec.Code)
+			}
+			if body := rec.Body.String(); len(test.body) > 0 && body != test.body {
+				t.Fatalf("expected body %q, got %q", test.body, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package why
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is the minimal time between two checks
+// if the certificate files changed.
+const certCheckInterval = time.Second * 10
+
+// certReloader holds a TLS certificate and reloads it if the
+// certificate or key file changed.
+type certReloader struct {
+	certFile  string
+	keyFile   string
+	mtx       sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	cr := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cr.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// latestModTime returns the newest modification time of the
+// certificate and key file.
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{cr.certFile, cr.keyFile} {
+		stat, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if stat.ModTime().After(latest) {
+			latest = stat.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (cr *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.mtx.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.mtx.Unlock()
+
+	return nil
+}
+
+// reloadIfChanged checks at most every certCheckInterval if the files
+// changed and reloads the certificate. If loading fails the old
+// certificate will be kept.
+func (cr *certReloader) reloadIfChanged() {
+	cr.mtx.Lock()
+	if time.Since(cr.lastCheck) < certCheckInterval {
+		cr.mtx.Unlock()
+		return
+	}
+	cr.lastCheck = time.Now()
+	lastMod := cr.modTime
+	cr.mtx.Unlock()
+
+	modTime, err := cr.latestModTime()
+	if err != nil || !modTime.After(lastMod) {
+		return
+	}
+
+	if err := cr.load(modTime); err != nil {
+		log.Printf("Error while reloading certificate: %v\n", err)
+		return
+	}
+
+	log.Println("Certificate reloaded.")
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.reloadIfChanged()
+
+	cr.mtx.RLock()
+	defer cr.mtx.RUnlock()
+	return cr.cert, nil
+}
+
+// redirectToHTTPS returns a handler that redirects all requests
+// to the same url on the HTTPS listener with the given address.
+func redirectToHTTPS(tlsAddress string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddress)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+
+		if len(port) > 0 && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
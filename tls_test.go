@@ -0,0 +1,156 @@
+package why
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate with the common name
+// and its key to the files and sets their modification time.
+func writeCert(t *testing.T, certFile string, keyFile string, name string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// commonName returns the common name of the certificate the
+// reloader currently serves.
+func commonName(t *testing.T, cr *certReloader) string {
+	cert, err := cr.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "why-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, "first", start)
+
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := commonName(t, cr); name != "first" {
+		t.Fatalf("expected the first certificate, got %q", name)
+	}
+
+	// Changes are only picked up after the check interval.
+	writeCert(t, certFile, keyFile, "second", start.Add(time.Minute))
+	if name := commonName(t, cr); name != "first" {
+		t.Fatalf("expected no reload before the check interval, got %q", name)
+	}
+
+	cr.lastCheck = time.Time{}
+	if name := commonName(t, cr); name != "second" {
+		t.Fatalf("expected the reloaded certificate, got %q", name)
+	}
+
+	// A broken certificate keeps the old one.
+	if err := ioutil.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(certFile, start.Add(2*time.Minute), start.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	cr.lastCheck = time.Time{}
+	if name := commonName(t, cr); name != "second" {
+		t.Fatalf("expected the old certificate to be kept, got %q", name)
+	}
+
+	// Missing files keep the old one too.
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	cr.lastCheck = time.Time{}
+	if name := commonName(t, cr); name != "second" {
+		t.Fatalf("expected the old certificate to be kept, got %q", name)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		address string
+		target  string
+		want    string
+	}{
+		{address: ":443", target: "http://example.com/page?a=1", want: "https://example.com/page?a=1"},
+		{address: "0.0.0.0:443", target: "http://example.com:80/page", want: "https://example.com/page"},
+		{address: ":8443", target: "http://example.com/page", want: "https://example.com:8443/page"},
+		{address: ":8443", target: "http://example.com:8080/page?a=1", want: "https://example.com:8443/page?a=1"},
+		{address: ":8443", target: "http://[::1]:8080/", want: "https://[::1]:8443/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address+" "+test.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			redirectToHTTPS(test.address).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.target, nil))
+
+			if rec.Code != http.StatusMovedPermanently {
+				t.Fatalf("expected status %d, got %d", http.StatusMovedPermanently, rec.Code)
+			}
+			if location := rec.Header().Get("Location"); location != test.want {
+				t.Fatalf("expected location %q, got %q", test.want, location)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package why
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader is the header that contains the id of a request.
+// A incoming id will be used instead of generating a new one.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of a incoming request id.
+const maxRequestIDLength = 128
+
+// Span is a timed operation inside of a request.
+type Span interface {
+	// SetAttribute adds a key value pair to the span.
+	SetAttribute(key string, value interface{})
+
+	// End finishes the span.
+	End()
+}
+
+// Tracer creates the spans of a request. The server creates spans
+// for the request itself, each script and the transpile, cache lookup,
+// compile, extension hook and run steps of a script. Child spans are
+// started with the context returned by the parent.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// TraceContext is the W3C trace context of a incoming request.
+type TraceContext struct {
+	TraceID  string
+	ParentID string
+	Flags    string
+}
+
+type traceContextKey struct{}
+
+// TraceContextFromContext returns the W3C trace context of the incoming
+// request. It's only available if PropagateTraceContext is enabled and
+// the request contained a valid traceparent header.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// RequestIDFromContext returns the id of the request the context
+// belongs to. This can be used by Go middlewares and extensions to
+// tag their own logs.
+func RequestIDFromContext(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// isHex checks if value is a lowercase hex string of the given
+// length, as the trace context doesn't allow uppercase hex.
+func isHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if (value[i] < '0' || value[i] > '9') && (value[i] < 'a' || value[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceParent parses a version 00 traceparent header.
+func parseTraceParent(header string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" || !isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return TraceContext{}, false
+	}
+
+	// All zero ids are invalid.
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return TraceContext{}, false
+	}
+
+	return TraceContext{TraceID: parts[1], ParentID: parts[2], Flags: parts[3]}, true
+}
+
+// validRequestID checks that a incoming request id is short and only
+// contains printable ascii characters, so it can be safely logged.
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestID returns the id of the request. A valid incoming
+// X-Request-ID is used first, then the trace id of the trace
+// context and a new random id otherwise.
+func requestID(r *http.Request, tc TraceContext, hasTrace bool) string {
+	if id := r.Header.Get(RequestIDHeader); validRequestID(id) {
+		return id
+	}
+
+	if hasTrace {
+		return tc.TraceID
+	}
+
+	return newRequestID()
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key string, value interface{}) {}
+func (nopSpan) End()                                       {}
+
+// startSpan starts a span with the tracer of the server. If no
+// tracer is set a span that does nothing will be returned. If the
+// debug toolbar is active the span is recorded for it as well.
+func (s *Server) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	var span Span = nopSpan{}
+	if s.tracer != nil {
+		ctx, span = s.tracer.StartSpan(ctx, name)
+	}
+
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok && info.debug != nil {
+		ctx, span = info.debug.startSpan(ctx, name, span)
+	}
+
+	return ctx, span
+}
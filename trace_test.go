@@ -0,0 +1,104 @@
+package why
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		header string
+		want   TraceContext
+		ok     bool
+	}{
+		{
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			want:   TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", ParentID: "00f067aa0ba902b7", Flags: "01"},
+			ok:     true,
+		},
+		{
+			header: " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00 ",
+			want:   TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", ParentID: "00f067aa0ba902b7", Flags: "00"},
+			ok:     true,
+		},
+		{header: ""},
+		{header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01"},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b-01"},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01"},
+		{header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00F067AA0BA902B7-01"},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0A"},
+		{header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			got, ok := parseTraceParent(test.header)
+			if ok != test.ok || got != test.want {
+				t.Fatalf("expected %v %v, got %v %v", test.want, test.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{id: "abc-123", want: true},
+		{id: "f47ac10b-58cc-4372-a567-0e02b2c3d479", want: true},
+		{id: strings.Repeat("a", maxRequestIDLength), want: true},
+		{id: ""},
+		{id: strings.Repeat("a", maxRequestIDLength+1)},
+		{id: "with space"},
+		{id: "line\nbreak"},
+		{id: "ümlaut"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.id, func(t *testing.T) {
+			if got := validRequestID(test.id); got != test.want {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tc := TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736"}
+
+	tests := []struct {
+		name     string
+		header   string
+		hasTrace bool
+		want     string
+	}{
+		{name: "incoming id", header: "abc", hasTrace: true, want: "abc"},
+		{name: "invalid incoming id", header: "a b", hasTrace: true, want: tc.TraceID},
+		{name: "trace id", hasTrace: true, want: tc.TraceID},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if len(test.header) > 0 {
+				r.Header.Set(RequestIDHeader, test.header)
+			}
+
+			if got := requestID(r, tc, test.hasTrace); got != test.want {
+				t.Fatalf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if id := requestID(r, TraceContext{}, false); !validRequestID(id) {
+		t.Fatalf("expected a valid generated id, got %q", id)
+	}
+}